@@ -1,8 +1,13 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/aryuuu/mines-party-server/configs"
 	"github.com/aryuuu/mines-party-server/routes"
@@ -37,6 +42,27 @@ func main() {
 		Handler: r,
 	}
 
-	log.Printf("Listening on port %s...", configs.Service.Port)
-	log.Fatal(server.ListenAndServe())
+	go func() {
+		log.Printf("Listening on port %s...", configs.Service.Port)
+		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	<-ctx.Done()
+	stop()
+
+	log.Printf("Shutting down within %v...", configs.Constant.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), configs.Constant.ShutdownTimeout)
+	defer cancel()
+
+	// the websockets are hijacked, the server does not wait for them
+	if err := gameUsecase.Shutdown(shutdownCtx); err != nil {
+		log.Printf("failed to close the rooms: %v", err)
+	}
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("failed to shut down the server: %v", err)
+	}
+	log.Print("Server stopped")
 }
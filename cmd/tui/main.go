@@ -21,10 +21,12 @@ https://github.com/charmbracelet/bubbletea/tree/master/tutorials/basics
 
 var (
 	// Available spinners
-	cellWidth  = 5
-	cellHeight = 2
-	greyColor  = lipgloss.Color("241")
-	cyanColor  = lipgloss.Color("69")
+	cellWidth   = 5
+	cellHeight  = 2
+	greyColor   = lipgloss.Color("241")
+	cyanColor   = lipgloss.Color("69")
+	redColor    = lipgloss.Color("203")
+	yellowColor = lipgloss.Color("221")
 	// need BG, grey border, DONE
 	closedCellStyle = lipgloss.NewStyle().
 			Width(cellWidth).
@@ -63,7 +65,12 @@ type mainModel struct {
 
 func newModel() mainModel {
 	m := mainModel{
-		field: minesweeper.NewField(8, 8, 10),
+		field: minesweeper.NewFieldBuilder().
+			WithRow(8).
+			WithCol(8).
+			WithMinesCount(10).
+			WithQuestionMarks(true).
+			Build(),
 		cursor: struct {
 			row int
 			col int
@@ -131,11 +138,17 @@ func (m mainModel) View() string {
 					rowStrings = append(rowStrings, openedCellStyle.Render(fmt.Sprintf("%4s", cell.GetValue())))
 				}
 			} else {
+				style := closedCellStyle
 				if m.cursor.row == i && m.cursor.col == j {
-					rowStrings = append(rowStrings, focusedClosedCellStyle.Render(fmt.Sprintf("%4s", cell.GetValue())))
-				} else {
-					rowStrings = append(rowStrings, closedCellStyle.Render(fmt.Sprintf("%4s", cell.GetValue())))
+					style = focusedClosedCellStyle
+				}
+				// make the marks stand out from the closed cells
+				if cell.IsFlagged() {
+					style = style.Copy().Foreground(redColor).Bold(true)
+				} else if cell.IsQuestioned() {
+					style = style.Copy().Foreground(yellowColor).Bold(true)
 				}
+				rowStrings = append(rowStrings, style.Render(fmt.Sprintf("%4s", cell.GetValue())))
 			}
 		}
 		cellStrings = append(cellStrings, lipgloss.JoinHorizontal(lipgloss.Top, rowStrings...))
@@ -1,19 +1,159 @@
 package configs
 
 import (
+	"crypto/rand"
+	"log"
 	"os"
 	"strconv"
+	"time"
+)
+
+const (
+	defaultSessionGraceSeconds = 60
+	defaultMatchTimeoutSeconds = 120
+	// defaultRoomCodeAlphabet leaves out the characters that are easily
+	// mistaken for one another: 0, 1, i, l and o
+	defaultRoomCodeAlphabet      = "abcdefghjkmnpqrstuvwxyz23456789"
+	defaultRoomCodeLength        = 5
+	defaultRoomCodeTTLSeconds    = 60
+	defaultPingIntervalSeconds   = 25
+	defaultWriteWaitSeconds      = 10
+	defaultMaxMessageSize        = 8192
+	defaultShutdownNoticeSeconds = 10
+	// defaultShutdownDrainSeconds is the time left to close the rooms after the
+	// notice
+	defaultShutdownDrainSeconds = 20
 )
 
 type constant struct {
+	// Capacity is the maximum number of rooms
 	Capacity int
+	// PlayerCapacity is the maximum number of players and spectators over
+	// all the rooms, 0 means no limit
+	PlayerCapacity int
+	// SessionSecret signs the session tokens, a random one is used when it
+	// is not set, so the tokens do not survive a restart
+	SessionSecret []byte
+	// SessionGrace is how long a disconnected player is kept in its room
+	SessionGrace time.Duration
+	// TrustProxy takes the client IP from the last X-Forwarded-For entry, it
+	// must only be set behind a single reverse proxy
+	TrustProxy bool
+	// MatchTimeout is how long a client waits in the matchmaking queue
+	MatchTimeout time.Duration
+	// RoomCodeAlphabet and RoomCodeLength shape the generated room codes,
+	// RoomCodeWords generates codes like brave-otter-42 instead
+	RoomCodeAlphabet string
+	RoomCodeLength   int
+	RoomCodeWords    bool
+	// RoomCodeTTL is how long a generated code is held for its room to be
+	// created
+	RoomCodeTTL time.Duration
+	// PingInterval is the time between two pings to a websocket client, a
+	// client that does not answer within PongWait is dropped
+	PingInterval time.Duration
+	PongWait     time.Duration
+	// WriteWait is how long a write to a websocket client may take
+	WriteWait time.Duration
+	// MaxMessageSize is the largest message read from a websocket client, in
+	// bytes
+	MaxMessageSize int64
+	// ShutdownNotice is how long the clients are warned before the server
+	// shuts down, the shutdown is cut short after ShutdownTimeout
+	ShutdownNotice  time.Duration
+	ShutdownTimeout time.Duration
+	// SnapshotPath is where the rooms left at shutdown are saved, they are
+	// not saved when it is empty
+	SnapshotPath string
 }
 
 func initConstant() *constant {
 	capacity, _ := strconv.Atoi(os.Getenv("CAPACITY"))
+	playerCapacity, _ := strconv.Atoi(os.Getenv("PLAYER_CAPACITY"))
+
+	secret := []byte(os.Getenv("SESSION_SECRET"))
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			log.Fatalf("failed to generate the session secret: %v", err)
+		}
+	}
+
+	grace, err := strconv.Atoi(os.Getenv("SESSION_GRACE_SECONDS"))
+	if err != nil || grace <= 0 {
+		grace = defaultSessionGraceSeconds
+	}
+
+	matchTimeout, err := strconv.Atoi(os.Getenv("MATCH_TIMEOUT_SECONDS"))
+	if err != nil || matchTimeout <= 0 {
+		matchTimeout = defaultMatchTimeoutSeconds
+	}
+
+	codeAlphabet := os.Getenv("ROOM_CODE_ALPHABET")
+	if len(codeAlphabet) < 2 {
+		codeAlphabet = defaultRoomCodeAlphabet
+	}
+	codeLength, err := strconv.Atoi(os.Getenv("ROOM_CODE_LENGTH"))
+	if err != nil || codeLength <= 0 {
+		codeLength = defaultRoomCodeLength
+	}
+	codeWords, _ := strconv.ParseBool(os.Getenv("ROOM_CODE_WORDS"))
+	codeTTL, err := strconv.Atoi(os.Getenv("ROOM_CODE_TTL_SECONDS"))
+	if err != nil || codeTTL <= 0 {
+		codeTTL = defaultRoomCodeTTLSeconds
+	}
+
+	pingInterval, err := strconv.Atoi(os.Getenv("PING_INTERVAL_SECONDS"))
+	if err != nil || pingInterval <= 0 {
+		pingInterval = defaultPingIntervalSeconds
+	}
+	// the pong must have time to come back before the next ping
+	pongWait, err := strconv.Atoi(os.Getenv("PONG_WAIT_SECONDS"))
+	if err != nil || pongWait <= pingInterval {
+		pongWait = pingInterval * 2
+	}
+	writeWait, err := strconv.Atoi(os.Getenv("WRITE_WAIT_SECONDS"))
+	if err != nil || writeWait <= 0 {
+		writeWait = defaultWriteWaitSeconds
+	}
+	maxMessageSize, err := strconv.ParseInt(os.Getenv("MAX_MESSAGE_SIZE"), 10, 64)
+	if err != nil || maxMessageSize <= 0 {
+		maxMessageSize = defaultMaxMessageSize
+	}
+
+	shutdownNotice, err := strconv.Atoi(os.Getenv("SHUTDOWN_NOTICE_SECONDS"))
+	if err != nil || shutdownNotice < 0 {
+		shutdownNotice = defaultShutdownNoticeSeconds
+	}
+	// closing the rooms must fit in after the notice
+	shutdownTimeout, err := strconv.Atoi(os.Getenv("SHUTDOWN_TIMEOUT_SECONDS"))
+	if err != nil || shutdownTimeout <= shutdownNotice {
+		shutdownTimeout = shutdownNotice + defaultShutdownDrainSeconds
+	}
+
+	trustProxy, _ := strconv.ParseBool(os.Getenv("TRUST_PROXY"))
 
 	result := &constant{
-		Capacity: capacity,
+		Capacity:       capacity,
+		PlayerCapacity: playerCapacity,
+		SessionSecret:  secret,
+		SessionGrace:   time.Duration(grace) * time.Second,
+		TrustProxy:     trustProxy,
+		MatchTimeout:   time.Duration(matchTimeout) * time.Second,
+
+		RoomCodeAlphabet: codeAlphabet,
+		RoomCodeLength:   codeLength,
+		RoomCodeWords:    codeWords,
+		RoomCodeTTL:      time.Duration(codeTTL) * time.Second,
+
+		PingInterval:   time.Duration(pingInterval) * time.Second,
+		PongWait:       time.Duration(pongWait) * time.Second,
+		WriteWait:      time.Duration(writeWait) * time.Second,
+		MaxMessageSize: maxMessageSize,
+
+		ShutdownNotice:  time.Duration(shutdownNotice) * time.Second,
+		ShutdownTimeout: time.Duration(shutdownTimeout) * time.Second,
+		SnapshotPath:    os.Getenv("SHUTDOWN_SNAPSHOT_PATH"),
 	}
 
 	return result
@@ -0,0 +1,160 @@
+package events
+
+import (
+	"encoding/base64"
+	"strings"
+
+	"github.com/aryuuu/mines-party-server/minesweeper"
+)
+
+// BoardEncoding is the wire format of the boards sent to a connection, it is
+// negotiated by the client when creating or joining a room.
+type BoardEncoding string
+
+const (
+	// JSONBoardEncoding sends the board as a [][]string, one string per cell
+	JSONBoardEncoding BoardEncoding = "json"
+	// PackedBoardEncoding sends the board as 4 bits per cell, row major, two
+	// cells per byte (high nibble first), base64 encoded
+	PackedBoardEncoding BoardEncoding = "packed"
+)
+
+// packed cell codes, 0 to 8 are the adjacent mines count
+const (
+	packedClosed   byte = 9
+	packedFlag     byte = 10
+	packedMine     byte = 11
+	packedQuestion byte = 12
+	packedUnknown  byte = 15
+)
+
+// ParseBoardEncoding returns the requested encoding, falling back to JSON for
+// anything unknown.
+func ParseBoardEncoding(val string) BoardEncoding {
+	if BoardEncoding(val) == PackedBoardEncoding {
+		return PackedBoardEncoding
+	}
+	return JSONBoardEncoding
+}
+
+// PackedBoard is the compact representation of a board.
+type PackedBoard struct {
+	Rows int    `json:"rows"`
+	Cols int    `json:"cols"`
+	Data string `json:"data"`
+	// Treasures are the row major indexes of the opened treasure cells, the
+	// data only holds their number
+	Treasures []int `json:"treasures,omitempty"`
+}
+
+// BoardEncoder is implemented by the messages carrying a board, so that the
+// board can be sent in the format negotiated by each connection.
+type BoardEncoder interface {
+	EncodeBoard(enc BoardEncoding) interface{}
+}
+
+// PackBoard packs a board into 4 bits per cell.
+func PackBoard(board *[][]string) *PackedBoard {
+	if board == nil {
+		return nil
+	}
+
+	rows, cols := boardSize(board)
+
+	data := make([]byte, (rows*cols+1)/2)
+	treasures := []int(nil)
+	i := 0
+	for _, row := range *board {
+		for _, val := range row {
+			if strings.HasPrefix(val, minesweeper.TREASURE_SYMBOL) {
+				treasures = append(treasures, i)
+				val = val[len(minesweeper.TREASURE_SYMBOL):]
+			}
+			code := packCell(val)
+			if i%2 == 0 {
+				data[i/2] = code << 4
+			} else {
+				data[i/2] |= code
+			}
+			i++
+		}
+	}
+
+	return &PackedBoard{
+		Rows:      rows,
+		Cols:      cols,
+		Data:      base64.StdEncoding.EncodeToString(data),
+		Treasures: treasures,
+	}
+}
+
+// boardSize returns the dimensions of the board, or zeros for no board.
+func boardSize(board *[][]string) (int, int) {
+	if board == nil || len(*board) == 0 {
+		return 0, 0
+	}
+	return len(*board), len((*board)[0])
+}
+
+func packCell(val string) byte {
+	switch {
+	case len(val) == 1 && val[0] >= '0' && val[0] <= '8':
+		return val[0] - '0'
+	case val == " ":
+		return packedClosed
+	case val == "F":
+		return packedFlag
+	case val == "X":
+		return packedMine
+	case val == "?":
+		return packedQuestion
+	}
+	return packedUnknown
+}
+
+func (m GameStartedBroadcast) EncodeBoard(enc BoardEncoding) interface{} {
+	if enc == PackedBoardEncoding {
+		m.PackedBoard = PackBoard(m.Board)
+		m.Board = nil
+	}
+	return &m
+}
+
+func (m BoardUpdatedBroadcast) EncodeBoard(enc BoardEncoding) interface{} {
+	if enc == PackedBoardEncoding {
+		m.PackedBoard = PackBoard(m.Board)
+		m.Board = nil
+	}
+	return &m
+}
+
+func (m MineOpenedBroadcast) EncodeBoard(enc BoardEncoding) interface{} {
+	if enc == PackedBoardEncoding {
+		m.PackedBoard = PackBoard(m.Board)
+		m.Board = nil
+	}
+	return &m
+}
+
+func (m GameClearedBroadcast) EncodeBoard(enc BoardEncoding) interface{} {
+	if enc == PackedBoardEncoding {
+		m.PackedBoard = PackBoard(m.Board)
+		m.Board = nil
+	}
+	return &m
+}
+
+func (m ChunksUnicast) EncodeBoard(enc BoardEncoding) interface{} {
+	if enc == PackedBoardEncoding {
+		chunks := make([]ChunkPayload, len(m.Chunks))
+		for i, chunk := range m.Chunks {
+			chunks[i] = ChunkPayload{
+				Row:         chunk.Row,
+				Col:         chunk.Col,
+				PackedCells: PackBoard(chunk.Cells),
+			}
+		}
+		m.Chunks = chunks
+	}
+	return &m
+}
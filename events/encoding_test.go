@@ -0,0 +1,51 @@
+package events_test
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/aryuuu/mines-party-server/events"
+)
+
+func TestPackBoard(t *testing.T) {
+	board := [][]string{
+		{"0", "1", "8"},
+		{" ", "F", "X"},
+	}
+
+	packed := events.PackBoard(&board)
+	if packed.Rows != 2 || packed.Cols != 3 {
+		t.Fatalf("expected a 2x3 board, got %dx%d", packed.Rows, packed.Cols)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(packed.Data)
+	if err != nil {
+		t.Fatalf("packed data is not valid base64: %v", err)
+	}
+
+	expected := []byte{0x01, 0x89, 0xab}
+	if string(data) != string(expected) {
+		t.Errorf("expected packed data %x, got %x", expected, data)
+	}
+}
+
+func TestPackBoardTreasures(t *testing.T) {
+	board := [][]string{
+		{"0", "T2"},
+		{"T0", " "},
+	}
+
+	packed := events.PackBoard(&board)
+	data, err := base64.StdEncoding.DecodeString(packed.Data)
+	if err != nil {
+		t.Fatalf("packed data is not valid base64: %v", err)
+	}
+
+	expected := []byte{0x02, 0x09}
+	if string(data) != string(expected) {
+		t.Errorf("expected packed data %x, got %x", expected, data)
+	}
+	if len(packed.Treasures) != 2 || packed.Treasures[0] != 1 || packed.Treasures[1] != 2 {
+		t.Errorf("expected treasures at 1 and 2, got %v", packed.Treasures)
+	}
+}
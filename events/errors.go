@@ -0,0 +1,95 @@
+package events
+
+import (
+	"time"
+
+	"github.com/aryuuu/mines-party-server/minesweeper"
+)
+
+// ErrorCode is a machine readable reason for a rejected action.
+type ErrorCode string
+
+const (
+	ErrorCodeUnknown       ErrorCode = "unknown"
+	ErrorCodeOutOfBounds   ErrorCode = "out_of_bounds"
+	ErrorCodeFlagOpenCell  ErrorCode = "flag_opened_cell"
+	ErrorCodeFlagNotOwned  ErrorCode = "flag_not_owned"
+	ErrorCodeFlagBudget    ErrorCode = "flag_budget_exceeded"
+	ErrorCodeFrozen        ErrorCode = "player_frozen"
+	ErrorCodeEliminated    ErrorCode = "player_eliminated"
+	ErrorCodeInvalidWindow ErrorCode = "invalid_chunk_window"
+	ErrorCodeNotEndless    ErrorCode = "not_endless"
+	ErrorCodeSpectator     ErrorCode = "spectator"
+
+	// the reasons a client cannot join a room
+	ErrorCodeRoomFull       ErrorCode = "room_full"
+	ErrorCodeSpectatorsFull ErrorCode = "spectators_full"
+	ErrorCodeNameTaken      ErrorCode = "name_taken"
+	ErrorCodeGameInProgress ErrorCode = "game_in_progress"
+	ErrorCodeBanned         ErrorCode = "banned"
+	ErrorCodeRoomNotFound   ErrorCode = "room_not_found"
+	ErrorCodeServerFull     ErrorCode = "server_full"
+	ErrorCodeAlreadyJoined  ErrorCode = "already_joined"
+	ErrorCodeWrongPassword  ErrorCode = "wrong_password"
+	ErrorCodeInviteRequired ErrorCode = "invite_required"
+	ErrorCodeRateLimited    ErrorCode = "rate_limited"
+	ErrorCodeShuttingDown   ErrorCode = "server_shutting_down"
+
+	// the reasons a client leaves the matchmaking queue without a match
+	ErrorCodeInvalidPreferences ErrorCode = "invalid_preferences"
+	ErrorCodeMatchTimeout       ErrorCode = "match_timeout"
+)
+
+var errorCodes = map[error]ErrorCode{
+	minesweeper.ErrOutOfBounds:         ErrorCodeOutOfBounds,
+	minesweeper.ErrOutsideFrontier:     ErrorCodeOutOfBounds,
+	minesweeper.ErrFlagOpenedCell:      ErrorCodeFlagOpenCell,
+	minesweeper.ErrFlagNotOwned:        ErrorCodeFlagNotOwned,
+	minesweeper.ErrFlagBudgetExceeded:  ErrorCodeFlagBudget,
+	minesweeper.ErrPlayerFrozen:        ErrorCodeFrozen,
+	minesweeper.ErrPlayerEliminated:    ErrorCodeEliminated,
+	minesweeper.ErrInvalidChunkWindow:  ErrorCodeInvalidWindow,
+	minesweeper.ErrChunkWindowTooLarge: ErrorCodeInvalidWindow,
+	minesweeper.ErrNotEndless:          ErrorCodeNotEndless,
+	minesweeper.ErrSpectator:           ErrorCodeSpectator,
+	minesweeper.ErrRoomFull:            ErrorCodeRoomFull,
+	minesweeper.ErrSpectatorsFull:      ErrorCodeSpectatorsFull,
+	minesweeper.ErrNameTaken:           ErrorCodeNameTaken,
+	minesweeper.ErrGameStarted:         ErrorCodeGameInProgress,
+	minesweeper.ErrBanned:              ErrorCodeBanned,
+	minesweeper.ErrWrongPassword:       ErrorCodeWrongPassword,
+	minesweeper.ErrInviteRequired:      ErrorCodeInviteRequired,
+}
+
+// ErrorUnicast tells a client why its action was rejected.
+type ErrorUnicast struct {
+	EventType EventType `json:"event_type"`
+	Code      ErrorCode `json:"code"`
+	Detail    string    `json:"detail"`
+	// RetryAfter is set on cooldown errors, in milliseconds
+	RetryAfter int64 `json:"retry_after,omitempty"`
+}
+
+// ErrorCodeOf returns the machine readable code of the error.
+func ErrorCodeOf(err error) ErrorCode {
+	code, ok := errorCodes[err]
+	if !ok {
+		return ErrorCodeUnknown
+	}
+	return code
+}
+
+func NewErrorUnicast(err error) *ErrorUnicast {
+	return &ErrorUnicast{
+		EventType: ErrorEvent,
+		Code:      ErrorCodeOf(err),
+		Detail:    err.Error(),
+	}
+}
+
+// NewCooldownErrorUnicast tells a client when it can act again.
+func NewCooldownErrorUnicast(err error, retryAfter time.Duration) *ErrorUnicast {
+	res := NewErrorUnicast(err)
+	res.RetryAfter = retryAfter.Milliseconds()
+	return res
+}
@@ -0,0 +1,79 @@
+package events
+
+import (
+	"github.com/aryuuu/mines-party-server/minesweeper"
+)
+
+const (
+	LobbySnapshotEvent    EventType = "lobby_snapshot"
+	LobbyRoomUpdatedEvent EventType = "lobby_room_updated"
+	LobbyRoomRemovedEvent EventType = "lobby_room_removed"
+)
+
+// LobbyRoom is the summary of a public room shown in the lobby
+type LobbyRoom struct {
+	RoomID            string `json:"id_room"`
+	PlayerCount       int    `json:"player_count"`
+	Capacity          int    `json:"capacity"`
+	SpectatorCount    int    `json:"spectator_count"`
+	SpectatorCapacity int    `json:"spectator_capacity"`
+	Difficulty        string `json:"difficulty"`
+	Mode              string `json:"mode"`
+	IsStarted         bool   `json:"is_started"`
+	HostName          string `json:"host_name"`
+	HasPassword       bool   `json:"has_password"`
+}
+
+// LobbyUpdate is pushed to the lobby subscribers when a public room changes
+// or goes away
+type LobbyUpdate struct {
+	EventType EventType  `json:"event_type"`
+	RoomID    string     `json:"id_room"`
+	Room      *LobbyRoom `json:"room,omitempty"`
+}
+
+type LobbySnapshot struct {
+	EventType EventType   `json:"event_type"`
+	Rooms     []LobbyRoom `json:"rooms"`
+}
+
+func NewLobbyRoom(gameRoom *minesweeper.GameRoom) LobbyRoom {
+	settings := gameRoom.GetSettings()
+	lobbyRoom := LobbyRoom{
+		RoomID:            gameRoom.RoomID,
+		PlayerCount:       gameRoom.PlayerCount(),
+		Capacity:          settings.Capacity,
+		SpectatorCount:    gameRoom.SpectatorCount(),
+		SpectatorCapacity: settings.SpectatorCapacity,
+		Difficulty:        settings.Difficulty,
+		Mode:              settings.Mode,
+		IsStarted:         gameRoom.HasStarted(),
+		HasPassword:       settings.Password != nil,
+	}
+	if host, ok := gameRoom.GetPlayer(settings.HostID); ok {
+		lobbyRoom.HostName = host.Name
+	}
+	return lobbyRoom
+}
+
+func NewLobbyRoomUpdated(room LobbyRoom) *LobbyUpdate {
+	return &LobbyUpdate{
+		EventType: LobbyRoomUpdatedEvent,
+		RoomID:    room.RoomID,
+		Room:      &room,
+	}
+}
+
+func NewLobbyRoomRemoved(roomID string) *LobbyUpdate {
+	return &LobbyUpdate{
+		EventType: LobbyRoomRemovedEvent,
+		RoomID:    roomID,
+	}
+}
+
+func NewLobbySnapshot(rooms []LobbyRoom) *LobbySnapshot {
+	return &LobbySnapshot{
+		EventType: LobbySnapshotEvent,
+		Rooms:     rooms,
+	}
+}
@@ -0,0 +1,72 @@
+package events
+
+import (
+	"time"
+)
+
+const (
+	QueueMatchEvent    EventType = "queue_match"
+	LeaveQueueEvent    EventType = "leave_queue"
+	QueuePositionEvent EventType = "queue_position"
+	MatchFoundEvent    EventType = "match_found"
+	MatchFailedEvent   EventType = "match_failed"
+)
+
+// MatchRequest is sent by a client looking for a game
+type MatchRequest struct {
+	EventType  EventType `json:"event_type"`
+	Difficulty string    `json:"difficulty"`
+	Mode       string    `json:"mode"`
+	// GroupSize is the number of players of the game
+	GroupSize int `json:"group_size"`
+	// Rating groups the players of similar skills, 0 matches any rating
+	Rating int `json:"rating,omitempty"`
+}
+
+// QueuePositionUnicast tells a queued client its position among the clients
+// with the same preferences
+type QueuePositionUnicast struct {
+	EventType EventType `json:"event_type"`
+	Position  int       `json:"position"`
+	Queued    int       `json:"queued"`
+}
+
+// MatchFoundUnicast gives the room a queued client joins with the invite
+type MatchFoundUnicast struct {
+	EventType   EventType `json:"event_type"`
+	RoomID      string    `json:"id_room"`
+	InviteToken string    `json:"invite_token"`
+	// ExpiresAt is in unix milliseconds
+	ExpiresAt int64 `json:"expires_at"`
+}
+
+type MatchFailedUnicast struct {
+	EventType EventType `json:"event_type"`
+	Reason    ErrorCode `json:"reason"`
+	Detail    string    `json:"detail"`
+}
+
+func NewQueuePositionUnicast(position, queued int) *QueuePositionUnicast {
+	return &QueuePositionUnicast{
+		EventType: QueuePositionEvent,
+		Position:  position,
+		Queued:    queued,
+	}
+}
+
+func NewMatchFoundUnicast(roomID string, inviteToken string, expiresAt time.Time) *MatchFoundUnicast {
+	return &MatchFoundUnicast{
+		EventType:   MatchFoundEvent,
+		RoomID:      roomID,
+		InviteToken: inviteToken,
+		ExpiresAt:   expiresAt.UnixMilli(),
+	}
+}
+
+func NewMatchFailedUnicast(reason ErrorCode, detail string) *MatchFailedUnicast {
+	return &MatchFailedUnicast{
+		EventType: MatchFailedEvent,
+		Reason:    reason,
+		Detail:    detail,
+	}
+}
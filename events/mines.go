@@ -1,28 +1,36 @@
 package events
 
 import (
+	"time"
+
 	"github.com/aryuuu/mines-party-server/minesweeper"
-	"github.com/gorilla/websocket"
 )
 
-type SocketEvent struct {
-	EventType EventType       `json:"event_type"`
-	RoomID    string          `json:"id_room"`
-	Conn      *websocket.Conn `json:"conn"`
-	Message   interface{}     `json:"message"`
-}
-
 // ClientEvent is events coming from client to the server
 type ClientEvent struct {
 	EventType   EventType             `json:"event_type,omitempty"`
 	ClientName  string                `json:"client_name"`
 	AvatarURL   string                `json:"avatar_url"`
+	Team        string                `json:"team,omitempty"`
 	Message     string                `json:"message,omitempty"`
 	PlayerID    string                `json:"id_player,omitempty"`
 	AgreeToKick bool                  `json:"agree_to_kick"`
 	Row         int                   `json:"row"`
 	Col         int                   `json:"col"`
 	Settings    *minesweeper.Settings `json:"settings"`
+	// Version is the last board version the client has seen
+	Version uint64 `json:"version"`
+	// BoardEncoding is the board format the client wants, see BoardEncoding
+	BoardEncoding string `json:"board_encoding,omitempty"`
+	// Window is the range of chunks requested on an endless board
+	Window *minesweeper.ChunkWindow `json:"window,omitempty"`
+	// SessionToken is the token of the session to resume
+	SessionToken string `json:"session_token,omitempty"`
+	// Spectate joins the room as a spectator
+	Spectate bool `json:"spectate,omitempty"`
+	// Password and InviteToken let the client into a protected room
+	Password    string `json:"password,omitempty"`
+	InviteToken string `json:"invite_token,omitempty"`
 }
 
 type EventType string
@@ -42,6 +50,10 @@ const (
 	OpenCellEvent              EventType = "open_cell"
 	FlagCellEvent              EventType = "flag_cell"
 	BoardUpdatedEvent          EventType = "board_updated"
+	BoardDiffEvent             EventType = "board_diff"
+	BoardSyncEvent             EventType = "sync_board"
+	ChunksRequestEvent         EventType = "request_chunks"
+	ChunksEvent                EventType = "chunks"
 	MineOpened                 EventType = "mine_opened"
 	GameCleared                EventType = "game_cleared"
 	KickPlayerEvent            EventType = "kick_player"
@@ -51,15 +63,34 @@ const (
 	ScoreUpdated               EventType = "score_updated"
 	SettingsUpdatedEvent       EventType = "settings_updated"
 	NotificationBroadcastEvent EventType = "notification"
-	UnicastSocketEvent         EventType = "unicast"
-	BroadcastSocketEvent       EventType = "broadcast"
+	ErrorEvent                 EventType = "error"
+	PenaltyEvent               EventType = "penalty"
+	TreasureSummaryEvent       EventType = "treasure_summary"
+	ResumeSessionEvent         EventType = "resume_session"
+	PlayerDisconnectedEvent    EventType = "player_disconnected"
+	PlayerResumedEvent         EventType = "player_resumed"
+	PromoteSpectatorEvent      EventType = "promote_spectator"
+	AssignTeamEvent            EventType = "assign_team"
+	CreateInviteEvent          EventType = "create_invite"
+	ServerShutdownEvent        EventType = "server_shutdown"
 )
 
+// ServerShutdownBroadcast warns the clients that the server goes away, with
+// the rooms, at ShutdownAt in unix milliseconds
+type ServerShutdownBroadcast struct {
+	EventType   EventType `json:"event_type"`
+	ShutdownAt  int64     `json:"shutdown_at"`
+	SecondsLeft int       `json:"seconds_left"`
+}
+
 type RoomCreatedUnicast struct {
 	EventType EventType             `json:"event_type"`
 	GameRoom  *minesweeper.GameRoom `json:"game_room"`
 	Success   bool                  `json:"success"`
 	Message   string                `json:"message"`
+	// SessionToken lets the player resume its session from another
+	// connection, see ResumeSessionEvent
+	SessionToken string `json:"session_token,omitempty"`
 }
 
 type GameStartedBroadcast struct {
@@ -67,7 +98,16 @@ type GameStartedBroadcast struct {
 	Success   bool      `json:"success"`
 	Detail    string    `json:"detail"`
 	// TODO: maybe put it in game created event?
-	Board *[][]string `json:"board"`
+	Board       *[][]string  `json:"board,omitempty"`
+	PackedBoard *PackedBoard `json:"packed_board,omitempty"`
+	Version     uint64       `json:"version"`
+	// RemainingMines is the mines count minus the flags
+	RemainingMines int    `json:"remaining_mines"`
+	Rows           int    `json:"rows,omitempty"`
+	Cols           int    `json:"cols,omitempty"`
+	Mode           string `json:"mode,omitempty"`
+	// ChunkSize is set in endless mode, where the board is sent in chunks
+	ChunkSize int `json:"chunk_size,omitempty"`
 }
 
 type GameStartedUnicast struct {
@@ -99,14 +139,70 @@ type GameEndedBroadcast struct {
 
 type RoomJoinedUnicast struct {
 	EventType EventType             `json:"event_type"`
+	Success   bool                  `json:"success"`
 	PlayerID  string                `json:"id_player"`
 	GameRoom  *minesweeper.GameRoom `json:"game_room"`
 	Detail    string                `json:"detail"`
+	// Reason tells why the client could not join, see ErrorCodeRoomFull
+	Reason       ErrorCode `json:"reason,omitempty"`
+	SessionToken string    `json:"session_token,omitempty"`
+}
+
+// SessionResumedUnicast answers a ResumeSessionEvent, the board and the
+// scores follow in their own events.
+type SessionResumedUnicast struct {
+	EventType EventType             `json:"event_type"`
+	Success   bool                  `json:"success"`
+	PlayerID  string                `json:"id_player,omitempty"`
+	GameRoom  *minesweeper.GameRoom `json:"game_room,omitempty"`
+	Detail    string                `json:"detail"`
+}
+
+// PlayerConnectionBroadcast tells the room that a player lost its connection
+// or came back.
+type PlayerConnectionBroadcast struct {
+	EventType EventType `json:"event_type"`
+	PlayerID  string    `json:"id_player"`
+	// GraceUntil is when the disconnected player leaves the room, in unix
+	// milliseconds
+	GraceUntil int64 `json:"grace_until,omitempty"`
 }
 
 type RoomJoinedBroacast struct {
 	EventType EventType           `json:"event_type"`
 	Player    *minesweeper.Player `json:"player"`
+	Spectator bool                `json:"spectator,omitempty"`
+}
+
+type InviteCreatedUnicast struct {
+	EventType   EventType `json:"event_type"`
+	Success     bool      `json:"success"`
+	Detail      string    `json:"detail"`
+	InviteToken string    `json:"invite_token,omitempty"`
+	// ExpiresAt is in unix milliseconds
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+}
+
+type SpectatorPromotedUnicast struct {
+	EventType EventType `json:"event_type"`
+	Success   bool      `json:"success"`
+	Detail    string    `json:"detail"`
+}
+
+type SpectatorPromotedBroadcast struct {
+	EventType EventType           `json:"event_type"`
+	Player    *minesweeper.Player `json:"player"`
+}
+
+type TeamAssignedUnicast struct {
+	EventType EventType `json:"event_type"`
+	Success   bool      `json:"success"`
+	Detail    string    `json:"detail"`
+}
+
+type TeamAssignedBroadcast struct {
+	EventType EventType           `json:"event_type"`
+	Player    *minesweeper.Player `json:"player"`
 }
 
 type VoteKickPlayerUnicast struct {
@@ -135,20 +231,64 @@ type GameLeftBroadcast struct {
 }
 
 type BoardUpdatedBroadcast struct {
-	EventType EventType   `json:"event_type"`
-	Board     *[][]string `json:"board"`
+	EventType      EventType    `json:"event_type"`
+	Board          *[][]string  `json:"board,omitempty"`
+	PackedBoard    *PackedBoard `json:"packed_board,omitempty"`
+	Version        uint64       `json:"version"`
+	RemainingMines int          `json:"remaining_mines"`
+	// Rows and Cols may change in expanding mode
+	Rows int `json:"rows"`
+	Cols int `json:"cols"`
+}
+
+// BoardDiffBroadcast carries only the cells changed by an action, clients
+// that notice a gap in the versions should ask for a sync.
+type BoardDiffBroadcast struct {
+	EventType      EventType                `json:"event_type"`
+	Version        uint64                   `json:"version"`
+	Changes        []minesweeper.CellChange `json:"changes"`
+	RemainingMines *int                     `json:"remaining_mines,omitempty"`
+	Rows           int                      `json:"rows,omitempty"`
+	Cols           int                      `json:"cols,omitempty"`
+}
+
+// TreasureSummaryBroadcast lists who claimed which treasures, it is sent when
+// a game with treasures ends.
+type TreasureSummaryBroadcast struct {
+	EventType EventType                   `json:"event_type"`
+	Claims    []minesweeper.TreasureClaim `json:"claims"`
+	// Totals is the value of the treasures claimed by each player
+	Totals map[string]int `json:"totals"`
+}
+
+// ChunkPayload is a chunk of an endless board, Row and Col are the chunk
+// coordinates.
+type ChunkPayload struct {
+	Row         int          `json:"row"`
+	Col         int          `json:"col"`
+	Cells       *[][]string  `json:"cells,omitempty"`
+	PackedCells *PackedBoard `json:"packed_cells,omitempty"`
+}
+
+type ChunksUnicast struct {
+	EventType EventType      `json:"event_type"`
+	ChunkSize int            `json:"chunk_size"`
+	Version   uint64         `json:"version"`
+	Chunks    []ChunkPayload `json:"chunks"`
 }
 
 type MineOpenedBroadcast struct {
-	EventType EventType                      `json:"event_type"`
-	Board     *[][]string                    `json:"board"`
-	Players   map[string]*minesweeper.Player `json:"players"`
+	EventType   EventType                      `json:"event_type"`
+	Board       *[][]string                    `json:"board,omitempty"`
+	PackedBoard *PackedBoard                   `json:"packed_board,omitempty"`
+	Players     map[string]*minesweeper.Player `json:"players"`
 }
 
 type GameClearedBroadcast struct {
-	EventType EventType                      `json:"event_type"`
-	Board     *[][]string                    `json:"board"`
-	Players   map[string]*minesweeper.Player `json:"players"`
+	EventType   EventType                      `json:"event_type"`
+	Board       *[][]string                    `json:"board,omitempty"`
+	PackedBoard *PackedBoard                   `json:"packed_board,omitempty"`
+	Players     map[string]*minesweeper.Player `json:"players"`
 }
 
 type ScoreUpdatedBroadcast struct {
@@ -162,6 +302,17 @@ type SettingsUpdatedBroadcast struct {
 	Settings  minesweeper.Settings `json:"settings"`
 }
 
+// PenaltyBroadcast tells the room that a player hit a mine and got penalized
+// according to the mine hit policy.
+type PenaltyBroadcast struct {
+	EventType EventType `json:"event_type"`
+	PlayerID  string    `json:"id_player"`
+	Policy    string    `json:"policy"`
+	Points    int       `json:"points"`
+	// FrozenUntil is in unix milliseconds
+	FrozenUntil int64 `json:"frozen_until,omitempty"`
+}
+
 type NotificationBroadcast struct {
 	EventType EventType `json:"event_type"`
 	Message   string    `json:"message"`
@@ -180,12 +331,13 @@ type PositionBroadcast struct {
 	Col       int       `json:"col"`
 }
 
-func NewRoomCreatedUnicast(room *minesweeper.GameRoom, message string) *RoomCreatedUnicast {
+func NewRoomCreatedUnicast(room *minesweeper.GameRoom, message string, sessionToken string) *RoomCreatedUnicast {
 	return &RoomCreatedUnicast{
-		EventType: CreateRoomEvent,
-		GameRoom:  room,
-		Success:   true,
-		Message:   message,
+		EventType:    CreateRoomEvent,
+		GameRoom:     room,
+		Success:      true,
+		Message:      message,
+		SessionToken: sessionToken,
 	}
 }
 
@@ -197,22 +349,67 @@ func NewFailCreateRoomUnicast(message string) *RoomCreatedUnicast {
 	}
 }
 
-func NewFailJoinRoomUnicast(roomID string, message string) *RoomJoinedUnicast {
+func NewFailJoinRoomUnicast(reason ErrorCode, detail string) *RoomJoinedUnicast {
 	return &RoomJoinedUnicast{
 		EventType: JoinRoomEvent,
-		Detail:    message,
+		Success:   false,
+		Detail:    detail,
+		Reason:    reason,
 	}
 }
 
-func NewRoomJoinedUnicast(playerID string, room *minesweeper.GameRoom) *RoomJoinedUnicast {
+func NewRoomJoinedUnicast(playerID string, room *minesweeper.GameRoom, sessionToken string) *RoomJoinedUnicast {
 	return &RoomJoinedUnicast{
-		EventType: JoinRoomEvent,
-		GameRoom:  room,
+		EventType:    JoinRoomEvent,
+		Success:      true,
+		GameRoom:     room,
+		PlayerID:     playerID,
+		Detail:       "success",
+		SessionToken: sessionToken,
+	}
+}
+
+func NewSessionResumedUnicast(playerID string, room *minesweeper.GameRoom) *SessionResumedUnicast {
+	return &SessionResumedUnicast{
+		EventType: ResumeSessionEvent,
+		Success:   true,
 		PlayerID:  playerID,
+		GameRoom:  room,
 		Detail:    "success",
 	}
 }
 
+func NewFailResumeSessionUnicast(detail string) *SessionResumedUnicast {
+	return &SessionResumedUnicast{
+		EventType: ResumeSessionEvent,
+		Success:   false,
+		Detail:    detail,
+	}
+}
+
+func NewPlayerDisconnectedBroadcast(playerID string, graceUntil time.Time) *PlayerConnectionBroadcast {
+	return &PlayerConnectionBroadcast{
+		EventType:  PlayerDisconnectedEvent,
+		PlayerID:   playerID,
+		GraceUntil: graceUntil.UnixMilli(),
+	}
+}
+
+func NewServerShutdownBroadcast(shutdownAt time.Time) *ServerShutdownBroadcast {
+	return &ServerShutdownBroadcast{
+		EventType:   ServerShutdownEvent,
+		ShutdownAt:  shutdownAt.UnixMilli(),
+		SecondsLeft: int(time.Until(shutdownAt).Round(time.Second) / time.Second),
+	}
+}
+
+func NewPlayerResumedBroadcast(playerID string) *PlayerConnectionBroadcast {
+	return &PlayerConnectionBroadcast{
+		EventType: PlayerResumedEvent,
+		PlayerID:  playerID,
+	}
+}
+
 func NewRoomJoinedBroadcast(player *minesweeper.Player) *RoomJoinedBroacast {
 	return &RoomJoinedBroacast{
 		EventType: JoinRoomBroadcastEvent,
@@ -220,6 +417,62 @@ func NewRoomJoinedBroadcast(player *minesweeper.Player) *RoomJoinedBroacast {
 	}
 }
 
+func NewSpectatorJoinedBroadcast(spectator *minesweeper.Player) *RoomJoinedBroacast {
+	return &RoomJoinedBroacast{
+		EventType: JoinRoomBroadcastEvent,
+		Player:    spectator,
+		Spectator: true,
+	}
+}
+
+func NewInviteCreatedUnicast(token string, expiresAt time.Time) *InviteCreatedUnicast {
+	return &InviteCreatedUnicast{
+		EventType:   CreateInviteEvent,
+		Success:     true,
+		Detail:      "success",
+		InviteToken: token,
+		ExpiresAt:   expiresAt.UnixMilli(),
+	}
+}
+
+func NewFailCreateInviteUnicast(detail string) *InviteCreatedUnicast {
+	return &InviteCreatedUnicast{
+		EventType: CreateInviteEvent,
+		Success:   false,
+		Detail:    detail,
+	}
+}
+
+func NewSpectatorPromotedUnicast(success bool, detail string) *SpectatorPromotedUnicast {
+	return &SpectatorPromotedUnicast{
+		EventType: PromoteSpectatorEvent,
+		Success:   success,
+		Detail:    detail,
+	}
+}
+
+func NewSpectatorPromotedBroadcast(player *minesweeper.Player) *SpectatorPromotedBroadcast {
+	return &SpectatorPromotedBroadcast{
+		EventType: PromoteSpectatorEvent,
+		Player:    player,
+	}
+}
+
+func NewTeamAssignedUnicast(success bool, detail string) *TeamAssignedUnicast {
+	return &TeamAssignedUnicast{
+		EventType: AssignTeamEvent,
+		Success:   success,
+		Detail:    detail,
+	}
+}
+
+func NewTeamAssignedBroadcast(player *minesweeper.Player) *TeamAssignedBroadcast {
+	return &TeamAssignedBroadcast{
+		EventType: AssignTeamEvent,
+		Player:    player,
+	}
+}
+
 func NewVoteKickPlayerUnicast(success bool) *VoteKickPlayerUnicast {
 	return &VoteKickPlayerUnicast{
 		EventType: VoteKickIssuedEvent,
@@ -263,12 +516,46 @@ func NewGameStartedUnicast(success bool, detail string) *GameStartedUnicast {
 	}
 }
 
-func NewGameStartedBroadcast(success bool, detail string, board *[][]string) *GameStartedBroadcast {
+func NewGameStartedBroadcast(success bool, detail string, board *[][]string, version uint64, remainingMines int) *GameStartedBroadcast {
+	rows, cols := boardSize(board)
+	return &GameStartedBroadcast{
+		EventType:      StartGameEvent,
+		Success:        success,
+		Detail:         detail,
+		Board:          board,
+		Version:        version,
+		RemainingMines: remainingMines,
+		Rows:           rows,
+		Cols:           cols,
+	}
+}
+
+func NewEndlessGameStartedBroadcast(detail string, version uint64) *GameStartedBroadcast {
 	return &GameStartedBroadcast{
 		EventType: StartGameEvent,
-		Success:   success,
+		Success:   true,
 		Detail:    detail,
-		Board:     board,
+		Version:   version,
+		Mode:      minesweeper.MODE_ENDLESS,
+		ChunkSize: minesweeper.CHUNK_SIZE,
+	}
+}
+
+func NewChunksUnicast(chunks []minesweeper.ChunkView, version uint64) *ChunksUnicast {
+	payload := make([]ChunkPayload, len(chunks))
+	for i, chunk := range chunks {
+		payload[i] = ChunkPayload{
+			Row:   chunk.Row,
+			Col:   chunk.Col,
+			Cells: chunk.Cells,
+		}
+	}
+
+	return &ChunksUnicast{
+		EventType: ChunksEvent,
+		ChunkSize: minesweeper.CHUNK_SIZE,
+		Version:   version,
+		Chunks:    payload,
 	}
 }
 
@@ -280,26 +567,22 @@ func NewChangeSettingsUnicast(success bool, detail string) *SettingsUpdatedUnica
 	}
 }
 
-func NewNotificationBroadcast(message string) *NotificationBroadcast {
-	return &NotificationBroadcast{
-		EventType: NotificationBroadcastEvent,
-		Message:   message,
+func NewPenaltyBroadcast(playerID, policy string, points int, frozenUntil time.Time) *PenaltyBroadcast {
+	res := &PenaltyBroadcast{
+		EventType: PenaltyEvent,
+		PlayerID:  playerID,
+		Policy:    policy,
+		Points:    points,
 	}
-}
-
-func NewBroadcastEvent(roomID string, message any) *SocketEvent {
-	return &SocketEvent{
-		EventType: BroadcastSocketEvent,
-		RoomID:    roomID,
-		Message:   message,
+	if !frozenUntil.IsZero() {
+		res.FrozenUntil = frozenUntil.UnixMilli()
 	}
+	return res
 }
 
-func NewUnicastEvent(roomID string, conn *websocket.Conn, message any) *SocketEvent {
-	return &SocketEvent{
-		EventType: UnicastSocketEvent,
-		RoomID:    roomID,
-		Conn:      conn,
+func NewNotificationBroadcast(message string) *NotificationBroadcast {
+	return &NotificationBroadcast{
+		EventType: NotificationBroadcastEvent,
 		Message:   message,
 	}
 }
@@ -329,10 +612,39 @@ func NewScoreUpdatedBroadcast(scoreboard map[string]int, timestamp int64) *Score
 	}
 }
 
-func NewBoardUpdatedBroadcast(board *[][]string) *BoardUpdatedBroadcast {
+func NewBoardUpdatedBroadcast(board *[][]string, version uint64, remainingMines int) *BoardUpdatedBroadcast {
+	rows, cols := boardSize(board)
 	return &BoardUpdatedBroadcast{
-		EventType: BoardUpdatedEvent,
-		Board:     board,
+		EventType:      BoardUpdatedEvent,
+		Board:          board,
+		Version:        version,
+		RemainingMines: remainingMines,
+		Rows:           rows,
+		Cols:           cols,
+	}
+}
+
+func NewBoardDiffBroadcast(diff *minesweeper.BoardDiff) *BoardDiffBroadcast {
+	return &BoardDiffBroadcast{
+		EventType:      BoardDiffEvent,
+		Version:        diff.Version,
+		Changes:        diff.Changes,
+		RemainingMines: diff.RemainingMines,
+		Rows:           diff.Rows,
+		Cols:           diff.Cols,
+	}
+}
+
+func NewTreasureSummaryBroadcast(claims []minesweeper.TreasureClaim) *TreasureSummaryBroadcast {
+	totals := map[string]int{}
+	for _, claim := range claims {
+		totals[claim.PlayerID] += claim.Value
+	}
+
+	return &TreasureSummaryBroadcast{
+		EventType: TreasureSummaryEvent,
+		Claims:    claims,
+		Totals:    totals,
 	}
 }
 
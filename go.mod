@@ -2,16 +2,16 @@ module github.com/aryuuu/mines-party-server
 
 go 1.19
 
-require github.com/charmbracelet/bubbletea v0.24.2
-
 require (
-	github.com/felixge/httpsnoop v1.0.3 // indirect
-	github.com/google/uuid v1.3.1 // indirect
-	github.com/gorilla/handlers v1.5.2 // indirect
-	github.com/gorilla/mux v1.8.0 // indirect
-	github.com/gorilla/websocket v1.5.0 // indirect
+	github.com/charmbracelet/bubbletea v0.24.2
+	github.com/google/uuid v1.3.1
+	github.com/gorilla/handlers v1.5.2
+	github.com/gorilla/mux v1.8.0
+	github.com/gorilla/websocket v1.5.0
 )
 
+require github.com/felixge/httpsnoop v1.0.3 // indirect
+
 require (
 	github.com/aymanbagabas/go-osc52/v2 v2.0.1 // indirect
 	github.com/charmbracelet/lipgloss v0.8.0
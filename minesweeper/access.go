@@ -0,0 +1,33 @@
+package minesweeper
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+)
+
+// MarshalJSON leaves the password out, only telling whether there is one.
+func (s Settings) MarshalJSON() ([]byte, error) {
+	type settings Settings
+	view := settings(s)
+	view.HasPassword = s.Password != nil
+	view.Password = nil
+
+	return json.Marshal(view)
+}
+
+// CheckPassword tells whether the password lets a client into the room, any
+// password does when the room has none.
+func (gr *GameRoom) CheckPassword(password string) bool {
+	gr.mu.RLock()
+	defer gr.mu.RUnlock()
+
+	if gr.Settings.Password == nil {
+		return true
+	}
+
+	// comparing the digests does not leak the length of the password
+	expected := sha256.Sum256([]byte(*gr.Settings.Password))
+	actual := sha256.Sum256([]byte(password))
+	return subtle.ConstantTimeCompare(expected[:], actual[:]) == 1
+}
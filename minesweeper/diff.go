@@ -0,0 +1,173 @@
+package minesweeper
+
+// DEFAULT_DIFF_HISTORY is the number of board versions kept around so that
+// lagging clients can catch up with a diff instead of a full board.
+const DEFAULT_DIFF_HISTORY = 128
+
+// CellChange is the new visible value of a single cell.
+type CellChange struct {
+	Row     int    `json:"row"`
+	Col     int    `json:"col"`
+	Value   string `json:"value"`
+	ActorID string `json:"id_actor,omitempty"`
+}
+
+// BoardDiff lists the cells that changed to get the board to Version.
+type BoardDiff struct {
+	Version uint64       `json:"version"`
+	Changes []CellChange `json:"changes"`
+	// Rows and Cols are the dimensions of the board at Version, they are not
+	// set on boards without fixed dimensions
+	Rows int `json:"rows,omitempty"`
+	Cols int `json:"cols,omitempty"`
+	// RemainingMines is the mines count minus the flags at Version, it is
+	// not set on boards without a known mines count
+	RemainingMines *int `json:"remaining_mines,omitempty"`
+}
+
+// journal keeps track of the board versions. It is not safe for concurrent
+// use on its own, the board embedding it is responsible for the locking.
+type journal struct {
+	// version is bumped on every mutation that changes the visible board
+	version uint64
+	pending []CellChange
+	history []BoardDiff
+}
+
+// add queues a change, it is published as a new board version by commit.
+func (j *journal) add(change CellChange) {
+	j.pending = append(j.pending, change)
+}
+
+// commit turns the pending changes into a new board version and returns
+// them, or nil when nothing changed.
+func (j *journal) commit() []CellChange {
+	if len(j.pending) == 0 {
+		return nil
+	}
+
+	changes := j.pending
+	j.version++
+	j.history = append(j.history, BoardDiff{
+		Version: j.version,
+		Changes: changes,
+	})
+	if len(j.history) > DEFAULT_DIFF_HISTORY {
+		j.history = j.history[len(j.history)-DEFAULT_DIFF_HISTORY:]
+	}
+	j.pending = nil
+
+	return changes
+}
+
+// reset publishes a new board version that cannot be expressed as a diff, the
+// clients behind it need the full board.
+func (j *journal) reset() {
+	j.version++
+	j.history = nil
+	j.pending = nil
+}
+
+func (j *journal) diffSince(version uint64) (*BoardDiff, bool) {
+	result := &BoardDiff{
+		Version: j.version,
+		Changes: []CellChange{},
+	}
+
+	if version == j.version {
+		return result, true
+	}
+
+	if version > j.version || len(j.history) == 0 || version+1 < j.history[0].Version {
+		return nil, false
+	}
+
+	for _, diff := range j.history {
+		if diff.Version <= version {
+			continue
+		}
+		result.Changes = append(result.Changes, diff.Changes...)
+	}
+
+	return result, true
+}
+
+// record queues the current visible value of the cell at the given position.
+func (f *Field) record(row, col int, actorID string) {
+	f.add(CellChange{
+		Row:     row,
+		Col:     col,
+		Value:   f.cells[row][col].GetValue(),
+		ActorID: actorID,
+	})
+}
+
+// commit publishes the pending changes as a new board version. It must be
+// called with f.mu held at the end of every mutation.
+func (f *Field) commit() {
+	if changes := f.journal.commit(); changes != nil {
+		f.patchSnapshot(changes)
+	}
+}
+
+// patchSnapshot brings an up to date snapshot to the new version by copying
+// only the rows that changed, the previous snapshot may still be in use by
+// readers and is left untouched.
+func (f *Field) patchSnapshot(changes []CellChange) {
+	f.snapshotMu.Lock()
+	defer f.snapshotMu.Unlock()
+
+	if f.snapshot == nil || f.snapshotVersion != f.version-1 {
+		return
+	}
+
+	board := make([][]string, len(*f.snapshot))
+	copy(board, *f.snapshot)
+	copied := map[int]bool{}
+	for _, change := range changes {
+		if !copied[change.Row] {
+			row := make([]string, len(board[change.Row]))
+			copy(row, board[change.Row])
+			board[change.Row] = row
+			copied[change.Row] = true
+		}
+		board[change.Row][change.Col] = change.Value
+	}
+
+	f.snapshot = &board
+	f.snapshotVersion = f.version
+}
+
+// Version returns the current board version.
+func (f *Field) Version() uint64 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.version
+}
+
+// DiffSince returns the changes needed to bring a board at the given version
+// up to date. It returns false when the version is not known anymore (or not
+// yet), in which case the client needs the full board.
+func (f *Field) DiffSince(version uint64) (*BoardDiff, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	diff, ok := f.diffSince(version)
+	if ok {
+		remainingMines := f.getRemainingMines()
+		diff.RemainingMines = &remainingMines
+		diff.Rows = f.row
+		diff.Cols = f.col
+	}
+	return diff, ok
+}
+
+// GetSnapshot returns the board as seen by the players along with its
+// version.
+func (f *Field) GetSnapshot() (*[][]string, uint64) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.getCellString(), f.version
+}
@@ -0,0 +1,589 @@
+package minesweeper
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aryuuu/mines-party-server/utils"
+)
+
+const (
+	// CHUNK_SIZE is the width and height of an endless board chunk
+	CHUNK_SIZE = 16
+	// MAX_CHUNK_WINDOW is the maximum number of chunks served per request
+	MAX_CHUNK_WINDOW = 64
+	// MAX_FLOOD_FILL bounds the cells opened by a single action on an endless
+	// board, zero regions are not guaranteed to be finite
+	MAX_FLOOD_FILL = 4096
+	// DEFAULT_RING_POINT is awarded for every ring the group pushes outward
+	DEFAULT_RING_POINT = 5
+)
+
+var endlessDensityMap = map[string]float64{
+	"easy":   0.12,
+	"medium": 0.16,
+	"hard":   0.2,
+}
+
+// ChunkCoord is the position of a chunk, chunk (0, 0) holds the cells from
+// (0, 0) to (CHUNK_SIZE-1, CHUNK_SIZE-1).
+type ChunkCoord struct {
+	Row int `json:"row"`
+	Col int `json:"col"`
+}
+
+// ChunkWindow is an inclusive range of chunks, usually the client viewport.
+type ChunkWindow struct {
+	FromRow int `json:"from_row"`
+	FromCol int `json:"from_col"`
+	ToRow   int `json:"to_row"`
+	ToCol   int `json:"to_col"`
+}
+
+// ChunkView is the visible content of a chunk.
+type ChunkView struct {
+	Row   int         `json:"row"`
+	Col   int         `json:"col"`
+	Cells *[][]string `json:"cells"`
+}
+
+// chunk holds the cells of a CHUNK_SIZE x CHUNK_SIZE square, row major. The
+// adjacent mines count of a cell needs the neighbouring chunks and is only
+// computed when the cell is opened.
+type chunk struct {
+	cells [CHUNK_SIZE * CHUNK_SIZE]Cell
+}
+
+// EndlessField is an unbounded board made of lazily generated chunks. The
+// mines of a chunk are derived from the seed and the chunk coordinates, and
+// the cells can only be opened or flagged next to the opened ones, so only
+// the chunks around the opened cells are ever materialized. All exported
+// methods are safe for concurrent use.
+type EndlessField struct {
+	mu sync.RWMutex
+
+	seed      int64
+	density   float64
+	chunks    map[ChunkCoord]*chunk
+	isStarted bool
+	origin    Location
+	openCells int
+	// maxDistance is the farthest opened cell from the origin, in rings
+	maxDistance int
+	// firstClickPolicy decides which cells are cleared of mines around the
+	// first click, see FIRST_CLICK_ZERO
+	firstClickPolicy string
+
+	questionMarks bool
+	flagRule
+	scorer
+
+	journal
+}
+
+type EndlessFieldBuilder struct {
+	field         *EndlessField
+	scoring       ScoringConfig
+	scoringPolicy string
+}
+
+func NewEndlessFieldBuilder() *EndlessFieldBuilder {
+	return &EndlessFieldBuilder{
+		field: &EndlessField{
+			seed:    time.Now().UnixNano(),
+			density: endlessDensityMap["hard"],
+			chunks:  map[ChunkCoord]*chunk{},
+
+			firstClickPolicy: FIRST_CLICK_ZERO,
+		},
+		// there is no cold open to speak of on a board that never clears
+		scoring: ScoringConfig{
+			CellScore:     DEFAULT_CELL_POINT,
+			MineScore:     DEFAULT_MINE_POINT,
+			RingScore:     DEFAULT_RING_POINT,
+			CountColdOpen: true,
+		},
+		scoringPolicy: SCORING_CLASSIC,
+	}
+}
+
+// WithSeed sets the seed the board is derived from, 0 keeps a random seed.
+func (eb *EndlessFieldBuilder) WithSeed(seed int64) *EndlessFieldBuilder {
+	if seed != 0 {
+		eb.field.seed = seed
+	}
+	return eb
+}
+
+func (eb *EndlessFieldBuilder) WithDifficulty(diff string) *EndlessFieldBuilder {
+	eb.field.density = endlessDensityMap["hard"]
+	if val, ok := endlessDensityMap[diff]; ok {
+		eb.field.density = val
+	}
+	return eb
+}
+
+func (eb *EndlessFieldBuilder) WithCellScore(val int) *EndlessFieldBuilder {
+	eb.scoring.CellScore = val
+	return eb
+}
+
+func (eb *EndlessFieldBuilder) WithMineScore(val int) *EndlessFieldBuilder {
+	eb.scoring.MineScore = val
+	return eb
+}
+
+// WithFirstClickPolicy sets which cells are cleared of mines around the first
+// click, see FIRST_CLICK_ZERO.
+func (eb *EndlessFieldBuilder) WithFirstClickPolicy(val string) *EndlessFieldBuilder {
+	eb.field.firstClickPolicy = val
+	return eb
+}
+
+// WithScoringPolicy sets how the actions are scored, see SCORING_CLASSIC.
+func (eb *EndlessFieldBuilder) WithScoringPolicy(val string) *EndlessFieldBuilder {
+	eb.scoringPolicy = val
+	return eb
+}
+
+func (eb *EndlessFieldBuilder) WithQuestionMarks(val bool) *EndlessFieldBuilder {
+	eb.field.questionMarks = val
+	return eb
+}
+
+func (eb *EndlessFieldBuilder) WithFlagPolicy(val string) *EndlessFieldBuilder {
+	eb.field.flagPolicy = val
+	return eb
+}
+
+func (eb *EndlessFieldBuilder) WithTeamResolver(teamOf func(playerID string) string) *EndlessFieldBuilder {
+	eb.field.teamOf = teamOf
+	return eb
+}
+
+func (eb *EndlessFieldBuilder) Build() *EndlessField {
+	eb.field.scoring = NewScoringPolicy(eb.scoringPolicy, eb.scoring)
+	return eb.field
+}
+
+// OpenCell opens the cell at the given position, flooding the zero regions up
+// to MAX_FLOOD_FILL cells, and returns the points earned by the action.
+func (e *EndlessField) OpenCell(row, col int, playerID string) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	defer e.commit()
+
+	outcome := ActionOutcome{PlayerID: playerID}
+	err := e.openCell(row, col, playerID, &outcome)
+	return e.score(outcome), err
+}
+
+func (e *EndlessField) openCell(row, col int, playerID string, outcome *ActionOutcome) error {
+	outcome.IsColdOpen = !e.isStarted
+	if !e.isStarted {
+		e.start(row, col)
+	} else if !e.isReachable(row, col) {
+		return ErrOutsideFrontier
+	}
+
+	cell := e.cellAt(row, col)
+	if cell.isFlagged {
+		return ErrOpenFlaggedCell
+	}
+
+	if cell.isOpen && cell.isMine {
+		return ErrOpenOpenedCell
+	}
+
+	if cell.isMine {
+		e.reveal(row, col, playerID, outcome)
+		return ErrOpenMine
+	}
+
+	if cell.isOpen {
+		outcome.IsChord = true
+	} else {
+		e.reveal(row, col, playerID, outcome)
+		if cell.adjacentMines == 0 {
+			outcome.IsOpening = true
+			outcome.BoardValue++
+		}
+	}
+
+	if int(cell.adjacentMines) == e.getAdjacentFlagCount(row, col, playerID) {
+		return e.quickOpenCell(row, col, playerID, outcome)
+	}
+
+	return nil
+}
+
+// ToggleFlagCell cycles the mark of the cell at the given position.
+func (e *EndlessField) ToggleFlagCell(row, col int, playerID string) (*Cell, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	defer e.commit()
+
+	if !e.isReachable(row, col) {
+		return nil, ErrOutsideFrontier
+	}
+	cell := e.cellAt(row, col)
+	if cell.isOpen {
+		return nil, ErrFlagOpenedCell
+	}
+
+	if !e.canMark(cell, playerID) {
+		return nil, ErrFlagNotOwned
+	}
+
+	cell.Mark(playerID, e.questionMarks)
+	e.record(row, col, playerID)
+
+	return cell, nil
+}
+
+// IsCleared is always false, there is no end to an endless board.
+func (e *EndlessField) IsCleared() bool {
+	return false
+}
+
+func (e *EndlessField) Version() uint64 {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.version
+}
+
+func (e *EndlessField) DiffSince(version uint64) (*BoardDiff, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.diffSince(version)
+}
+
+// GetSnapshot has no full board to offer, clients of an endless board fetch
+// chunk windows instead.
+func (e *EndlessField) GetSnapshot() (*[][]string, uint64) {
+	return nil, e.Version()
+}
+
+// GetMaxDistance returns how far, in rings around the first opened cell, the
+// players have pushed.
+func (e *EndlessField) GetMaxDistance() int {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.maxDistance
+}
+
+// GetOriginChunk returns the chunk of the first opened cell, chunk (0, 0)
+// until then.
+func (e *EndlessField) GetOriginChunk() ChunkCoord {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return GetChunkOf(e.origin.row, e.origin.col)
+}
+
+func (e *EndlessField) GetOpenCellCount() int {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.openCells
+}
+
+// GetChunkWindow returns the materialized chunks within the window along with
+// the board version, the chunks left out have no opened or flagged cells.
+func (e *EndlessField) GetChunkWindow(window ChunkWindow) ([]ChunkView, uint64, error) {
+	rows := window.ToRow - window.FromRow + 1
+	cols := window.ToCol - window.FromCol + 1
+	if rows <= 0 || cols <= 0 {
+		return nil, 0, ErrInvalidChunkWindow
+	}
+	if rows*cols > MAX_CHUNK_WINDOW {
+		return nil, 0, ErrChunkWindowTooLarge
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	result := []ChunkView{}
+	for coord, ch := range e.chunks {
+		if coord.Row < window.FromRow || coord.Row > window.ToRow || coord.Col < window.FromCol || coord.Col > window.ToCol {
+			continue
+		}
+
+		cells := make([][]string, CHUNK_SIZE)
+		for i := range cells {
+			cells[i] = make([]string, CHUNK_SIZE)
+			for j := range cells[i] {
+				cells[i][j] = ch.cells[i*CHUNK_SIZE+j].GetValue()
+			}
+		}
+		result = append(result, ChunkView{
+			Row:   coord.Row,
+			Col:   coord.Col,
+			Cells: &cells,
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Row != result[j].Row {
+			return result[i].Row < result[j].Row
+		}
+		return result[i].Col < result[j].Col
+	})
+
+	return result, e.version, nil
+}
+
+// GetChunkOf returns the chunk holding the given cell.
+func GetChunkOf(row, col int) ChunkCoord {
+	return ChunkCoord{
+		Row: floorDiv(row, CHUNK_SIZE),
+		Col: floorDiv(col, CHUNK_SIZE),
+	}
+}
+
+// start sets the origin of the board and clears the cells protected by the
+// first click policy.
+func (e *EndlessField) start(row, col int) {
+	e.isStarted = true
+	e.origin = Location{
+		row: row,
+		col: col,
+	}
+
+	for i := row - 1; i <= row+1; i++ {
+		for j := col - 1; j <= col+1; j++ {
+			if isFirstClickProtected(e.firstClickPolicy, i, j, e.origin) {
+				e.cellAt(i, j).isMine = false
+			}
+		}
+	}
+}
+
+func (e *EndlessField) quickOpenCell(row, col int, playerID string, outcome *ActionOutcome) error {
+	// the neighbours of a cell without adjacent mines belong to its opening
+	inOpening := e.cellAt(row, col).adjacentMines == 0
+	opened := 0
+	queue := []Location{}
+	open := func(loc Location) {
+		e.reveal(loc.row, loc.col, playerID, outcome)
+		opened++
+
+		if e.cellAt(loc.row, loc.col).adjacentMines == 0 {
+			queue = append(queue, loc)
+		}
+	}
+
+	head := 0
+	for _, loc := range getNeighbours(row, col) {
+		cell := e.cellAt(loc.row, loc.col)
+
+		if cell.isFlagged || cell.isOpen {
+			continue
+		}
+
+		if cell.isMine {
+			e.reveal(loc.row, loc.col, playerID, outcome)
+			return ErrOpenMine
+		}
+
+		open(loc)
+		if !inOpening && cell.adjacentMines == 0 {
+			outcome.BoardValue++
+		}
+
+		// flood each opening before moving to the next neighbour, see
+		// Field.quickOpenCell
+		for ; head < len(queue) && opened < MAX_FLOOD_FILL; head++ {
+			loc := queue[head]
+			for _, next := range getNeighbours(loc.row, loc.col) {
+				cell := e.cellAt(next.row, next.col)
+				if cell.isFlagged || cell.isOpen {
+					continue
+				}
+
+				open(next)
+			}
+		}
+	}
+
+	return nil
+}
+
+// reveal opens a single cell and adds it to the outcome of the action.
+func (e *EndlessField) reveal(row, col int, playerID string, outcome *ActionOutcome) {
+	cell := e.cellAt(row, col)
+	cell.adjacentMines = uint8(e.getAdjacentMinesCount(row, col))
+	cell.Open(playerID)
+	e.record(row, col, playerID)
+
+	if cell.isMine {
+		outcome.HitMine = true
+		return
+	}
+
+	e.openCells++
+	outcome.CellsRevealed++
+	if cell.adjacentMines > 0 && !e.bordersOpening(row, col) {
+		outcome.BoardValue++
+	}
+
+	distance := utils.Abs(row - e.origin.row)
+	if d := utils.Abs(col - e.origin.col); d > distance {
+		distance = d
+	}
+	if distance > e.maxDistance {
+		outcome.RingsPushed += distance - e.maxDistance
+		e.maxDistance = distance
+	}
+}
+
+// bordersOpening tells whether the numbered cell at the given position is
+// uncovered along with an opening.
+func (e *EndlessField) bordersOpening(row, col int) bool {
+	for _, loc := range getNeighbours(row, col) {
+		if !e.cellAt(loc.row, loc.col).isMine && e.getAdjacentMinesCount(loc.row, loc.col) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *EndlessField) record(row, col int, actorID string) {
+	e.add(CellChange{
+		Row:     row,
+		Col:     col,
+		Value:   e.cellAt(row, col).GetValue(),
+		ActorID: actorID,
+	})
+}
+
+func (e *EndlessField) commit() {
+	e.journal.commit()
+}
+
+func (e *EndlessField) getAdjacentMinesCount(row, col int) int {
+	result := 0
+	for _, loc := range getNeighbours(row, col) {
+		if e.cellAt(loc.row, loc.col).isMine {
+			result++
+		}
+	}
+	return result
+}
+
+func (e *EndlessField) getAdjacentFlagCount(row, col int, playerID string) int {
+	result := 0
+	for _, loc := range getNeighbours(row, col) {
+		if e.countsAsFlag(e.cellAt(loc.row, loc.col), playerID) {
+			result++
+		}
+	}
+	return result
+}
+
+// cellAt returns the cell at the given position, materializing its chunk if
+// needed.
+func (e *EndlessField) cellAt(row, col int) *Cell {
+	coord := GetChunkOf(row, col)
+	ch, ok := e.chunks[coord]
+	if !ok {
+		ch = e.generateChunk(coord)
+		e.chunks[coord] = ch
+	}
+
+	return &ch.cells[(row-coord.Row*CHUNK_SIZE)*CHUNK_SIZE+(col-coord.Col*CHUNK_SIZE)]
+}
+
+// isOpen tells whether the cell at the given position is open, without
+// materializing its chunk.
+func (e *EndlessField) isOpen(row, col int) bool {
+	coord := GetChunkOf(row, col)
+	ch, ok := e.chunks[coord]
+	if !ok {
+		return false
+	}
+
+	return ch.cells[(row-coord.Row*CHUNK_SIZE)*CHUNK_SIZE+(col-coord.Col*CHUNK_SIZE)].isOpen
+}
+
+// isReachable tells whether the cell at the given position is open or next to
+// an open cell. The cells further out are out of play, so that every opened
+// cell is connected to the origin.
+func (e *EndlessField) isReachable(row, col int) bool {
+	if e.isOpen(row, col) {
+		return true
+	}
+	for _, loc := range getNeighbours(row, col) {
+		if e.isOpen(loc.row, loc.col) {
+			return true
+		}
+	}
+	return false
+}
+
+// generateChunk lays the mines of a chunk, the layout only depends on the seed
+// and the chunk coordinates.
+func (e *EndlessField) generateChunk(coord ChunkCoord) *chunk {
+	ch := &chunk{}
+	cellCount := CHUNK_SIZE * CHUNK_SIZE
+	mines := int(e.density*float64(cellCount) + 0.5)
+
+	rng := rand.New(rand.NewSource(chunkSeed(e.seed, coord)))
+	for _, pick := range rng.Perm(cellCount)[:mines] {
+		ch.cells[pick].isMine = true
+	}
+
+	if e.isStarted {
+		for i := e.origin.row - 1; i <= e.origin.row+1; i++ {
+			for j := e.origin.col - 1; j <= e.origin.col+1; j++ {
+				if GetChunkOf(i, j) != coord || !isFirstClickProtected(e.firstClickPolicy, i, j, e.origin) {
+					continue
+				}
+				ch.cells[(i-coord.Row*CHUNK_SIZE)*CHUNK_SIZE+(j-coord.Col*CHUNK_SIZE)].isMine = false
+			}
+		}
+	}
+
+	return ch
+}
+
+// chunkSeed mixes the board seed with the chunk coordinates (splitmix64).
+func chunkSeed(seed int64, coord ChunkCoord) int64 {
+	x := uint64(seed) ^ uint64(int64(coord.Row))*0x9e3779b97f4a7c15 ^ uint64(int64(coord.Col))*0xc2b2ae3d27d4eb4f
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return int64(x ^ (x >> 31))
+}
+
+func floorDiv(a, b int) int {
+	result := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		result--
+	}
+	return result
+}
+
+// getNeighbours returns the locations around the given position on an
+// unbounded board.
+func getNeighbours(row, col int) []Location {
+	result := make([]Location, 0, 8)
+	for i := row - 1; i <= row+1; i++ {
+		for j := col - 1; j <= col+1; j++ {
+			if i == row && j == col {
+				continue
+			}
+
+			result = append(result, Location{
+				row: i,
+				col: j,
+			})
+		}
+	}
+	return result
+}
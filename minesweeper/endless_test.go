@@ -0,0 +1,122 @@
+package minesweeper_test
+
+import (
+	"testing"
+
+	"github.com/aryuuu/mines-party-server/minesweeper"
+)
+
+func TestEndlessFieldIsDeterministic(t *testing.T) {
+	first := minesweeper.NewEndlessFieldBuilder().WithSeed(42).Build()
+	second := minesweeper.NewEndlessFieldBuilder().WithSeed(42).Build()
+
+	// start far from the origin and across a chunk boundary
+	for _, field := range []*minesweeper.EndlessField{first, second} {
+		if _, err := field.OpenCell(-1, minesweeper.CHUNK_SIZE*100, "p1"); err != nil {
+			t.Fatalf("failed to open the first cell: %v", err)
+		}
+	}
+
+	firstDiff, _ := first.DiffSince(0)
+	secondDiff, _ := second.DiffSince(0)
+	if len(firstDiff.Changes) != len(secondDiff.Changes) {
+		t.Fatalf("expected the same changes, got %d and %d", len(firstDiff.Changes), len(secondDiff.Changes))
+	}
+	for i := range firstDiff.Changes {
+		if firstDiff.Changes[i] != secondDiff.Changes[i] {
+			t.Errorf("change %d differs: %+v and %+v", i, firstDiff.Changes[i], secondDiff.Changes[i])
+		}
+	}
+}
+
+func TestEndlessFieldChunkWindow(t *testing.T) {
+	field := minesweeper.NewEndlessFieldBuilder().WithSeed(7).Build()
+	if _, err := field.OpenCell(0, 0, "p1"); err != nil {
+		t.Fatalf("failed to open the first cell: %v", err)
+	}
+
+	// chunks far away from the opened cells are never materialized
+	far := minesweeper.ChunkWindow{FromRow: 100, FromCol: 100, ToRow: 101, ToCol: 101}
+	chunks, _, err := field.GetChunkWindow(far)
+	if err != nil {
+		t.Fatalf("failed to get chunks: %v", err)
+	}
+	if len(chunks) != 0 {
+		t.Errorf("expected no chunks far from the origin, got %d", len(chunks))
+	}
+
+	near := minesweeper.ChunkWindow{FromRow: -1, FromCol: -1, ToRow: 1, ToCol: 1}
+	chunks, version, err := field.GetChunkWindow(near)
+	if err != nil {
+		t.Fatalf("failed to get chunks: %v", err)
+	}
+	if len(chunks) == 0 || version != field.Version() {
+		t.Errorf("expected the chunks around the origin at version %d, got %d chunks at version %d", field.Version(), len(chunks), version)
+	}
+
+	huge := minesweeper.ChunkWindow{FromRow: 0, FromCol: 0, ToRow: 100, ToCol: 100}
+	if _, _, err := field.GetChunkWindow(huge); err != minesweeper.ErrChunkWindowTooLarge {
+		t.Errorf("expected ErrChunkWindowTooLarge, got %v", err)
+	}
+}
+
+func TestEndlessFieldFrontier(t *testing.T) {
+	field := minesweeper.NewEndlessFieldBuilder().WithSeed(7).Build()
+	if _, err := field.OpenCell(0, 0, "p1"); err != nil {
+		t.Fatalf("failed to open the first cell: %v", err)
+	}
+	distance := field.GetMaxDistance()
+
+	far := minesweeper.CHUNK_SIZE * 1000
+	if _, err := field.OpenCell(far, far, "p1"); err != minesweeper.ErrOutsideFrontier {
+		t.Errorf("expected ErrOutsideFrontier, got %v", err)
+	}
+	if _, err := field.ToggleFlagCell(far, far, "p1"); err != minesweeper.ErrOutsideFrontier {
+		t.Errorf("expected ErrOutsideFrontier, got %v", err)
+	}
+	if field.GetMaxDistance() != distance {
+		t.Errorf("expected no ring pushed, got %d instead of %d", field.GetMaxDistance(), distance)
+	}
+
+	window := minesweeper.ChunkWindow{FromRow: 999, FromCol: 999, ToRow: 1001, ToCol: 1001}
+	if chunks, _, _ := field.GetChunkWindow(window); len(chunks) != 0 {
+		t.Errorf("expected no chunk far from the origin, got %d", len(chunks))
+	}
+}
+
+func TestEndlessFieldFirstClickPolicy(t *testing.T) {
+	// the 3x3 around the first click spans four chunks
+	corner := minesweeper.CHUNK_SIZE - 1
+	for _, policy := range []string{minesweeper.FIRST_CLICK_ZERO, minesweeper.FIRST_CLICK_SAFE, minesweeper.FIRST_CLICK_NONE} {
+		mines, numbers := 0, 0
+		for seed := int64(0); seed < 200; seed++ {
+			field := minesweeper.NewEndlessFieldBuilder().WithSeed(seed).WithFirstClickPolicy(policy).Build()
+			_, err := field.OpenCell(corner, corner, "p1")
+			if err == minesweeper.ErrOpenMine {
+				mines++
+				continue
+			}
+			if err != nil {
+				t.Fatalf("%s: failed to open the first cell: %v", policy, err)
+			}
+			if field.GetOpenCellCount() == 1 {
+				numbers++
+			}
+		}
+
+		switch policy {
+		case minesweeper.FIRST_CLICK_ZERO:
+			if mines != 0 || numbers != 0 {
+				t.Errorf("%s: expected every first click to open a region, got %d mines and %d numbers", policy, mines, numbers)
+			}
+		case minesweeper.FIRST_CLICK_SAFE:
+			if mines != 0 || numbers == 0 {
+				t.Errorf("%s: expected safe first clicks showing numbers, got %d mines and %d numbers", policy, mines, numbers)
+			}
+		case minesweeper.FIRST_CLICK_NONE:
+			if mines == 0 {
+				t.Errorf("%s: expected some first clicks on a mine", policy)
+			}
+		}
+	}
+}
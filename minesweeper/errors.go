@@ -11,4 +11,30 @@ var (
 	ErrOpenFlaggedCell       = errors.New("cannot open a flagged cell")
 	ErrOpenMine              = errors.New("opened a mine")
 	ErrTooManyMines          = errors.New("too many mines")
+	ErrGuessNeeded           = errors.New("board cannot be solved without guessing")
+	ErrOutOfBounds           = errors.New("cell is out of bounds")
+	ErrOutsideFrontier       = errors.New("cell is away from the opened cells")
+	ErrGameNotStarted        = errors.New("game is not started")
+	ErrInvalidChunkWindow    = errors.New("invalid chunk window")
+	ErrChunkWindowTooLarge   = errors.New("chunk window is too large")
+	ErrNotEndless            = errors.New("game is not in endless mode")
+	ErrNotSurvival           = errors.New("game is not in survival mode")
+	ErrNotExpanding          = errors.New("game is not in expanding mode")
+	ErrBoardTooLarge         = errors.New("board cannot grow any larger")
+	ErrEmptyGrowth           = errors.New("board must grow by at least a row or a column")
+	ErrNotCleared            = errors.New("board is not cleared")
+	ErrFlagNotOwned          = errors.New("cannot remove a flag placed by someone else")
+	ErrFlagBudgetExceeded    = errors.New("there are already as many flags as mines")
+	ErrPlayerFrozen          = errors.New("player is frozen")
+	ErrPlayerEliminated      = errors.New("player is eliminated")
+	ErrSpectator             = errors.New("spectators cannot play")
+	ErrNotSpectator          = errors.New("player is not a spectator")
+	ErrNotPlayer             = errors.New("player is not in the room")
+	ErrGameStarted           = errors.New("game is already started")
+	ErrRoomFull              = errors.New("room is full")
+	ErrSpectatorsFull        = errors.New("there is no more room for spectators")
+	ErrNameTaken             = errors.New("name is already taken")
+	ErrBanned                = errors.New("player is banned from the room")
+	ErrWrongPassword         = errors.New("wrong password")
+	ErrInviteRequired        = errors.New("room is invite only")
 )
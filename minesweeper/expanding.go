@@ -0,0 +1,112 @@
+package minesweeper
+
+const (
+	// DEFAULT_GROW_ROWS and DEFAULT_GROW_COLS are the rows appended at the
+	// bottom and the columns appended on the right by a growth
+	DEFAULT_GROW_ROWS = 5
+	DEFAULT_GROW_COLS = 10
+	// DEFAULT_GROW_DENSITY_STEP is added to the current mine density of the
+	// board for the new territory, so that every growth is harder than the last
+	DEFAULT_GROW_DENSITY_STEP = 0.01
+	DEFAULT_MAX_GROW_DENSITY  = 0.3
+	// MAX_EXPANDING_CELLS caps the size of an expanding board
+	MAX_EXPANDING_CELLS = 1000 * 1000
+)
+
+// Grow appends rows at the bottom and columns on the right of the board and
+// lays mines in the new territory only, the numbers of the opened cells along
+// the old boundary are updated. Diffs cannot express a change of dimensions,
+// so the history is reset and clients need the full board after a growth. It
+// returns the number of mines laid.
+func (f *Field) Grow(rows, cols int) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.isStarted {
+		return 0, ErrGameNotStarted
+	}
+
+	if rows == 0 && cols == 0 {
+		return 0, ErrEmptyGrowth
+	}
+	newRow, newCol := f.row+rows, f.col+cols
+	if rows < 0 || cols < 0 || newRow*newCol > MAX_EXPANDING_CELLS {
+		return 0, ErrBoardTooLarge
+	}
+
+	density := float64(f.minesCount)/float64(f.row*f.col) + DEFAULT_GROW_DENSITY_STEP
+	if density > DEFAULT_MAX_GROW_DENSITY {
+		density = DEFAULT_MAX_GROW_DENSITY
+	}
+
+	// the new cells share a single allocation, see generateCells
+	backing := make([]Cell, newRow*newCol-f.row*f.col)
+	territory := make([]Location, 0, len(backing))
+	for i := 0; i < f.row; i++ {
+		for j := f.col; j < newCol; j++ {
+			f.cells[i] = append(f.cells[i], &backing[len(territory)])
+			territory = append(territory, Location{row: i, col: j})
+		}
+	}
+	for i := f.row; i < newRow; i++ {
+		row := make([]*Cell, newCol)
+		for j := range row {
+			row[j] = &backing[len(territory)]
+			territory = append(territory, Location{row: i, col: j})
+		}
+		f.cells = append(f.cells, row)
+	}
+
+	oldRow, oldCol := f.row, f.col
+	f.row, f.col = newRow, newCol
+
+	// the new cells along the old boundary count the old mines next to them
+	isNew := func(loc Location) bool {
+		return loc.row >= oldRow || loc.col >= oldCol
+	}
+	boundary := []Location{}
+	for i := 0; i < oldRow; i++ {
+		boundary = append(boundary, Location{row: i, col: oldCol - 1})
+	}
+	for j := 0; j < oldCol-1; j++ {
+		boundary = append(boundary, Location{row: oldRow - 1, col: j})
+	}
+	for _, loc := range boundary {
+		if !f.cells[loc.row][loc.col].isMine {
+			continue
+		}
+		for _, next := range f.getNeighbours(loc.row, loc.col) {
+			if isNew(next) {
+				f.cells[next.row][next.col].adjacentMines++
+			}
+		}
+	}
+
+	count := int(float64(len(territory))*density + 0.5)
+	rng := f.getRand()
+	for k := 0; k < count; k++ {
+		n := k + rng.Intn(len(territory)-k)
+		territory[k], territory[n] = territory[n], territory[k]
+		loc := territory[k]
+
+		f.cells[loc.row][loc.col].isMine = true
+		f.minesCount++
+		for _, next := range f.getNeighbours(loc.row, loc.col) {
+			f.cells[next.row][next.col].adjacentMines++
+		}
+	}
+
+	f.generateTreasures(territory[count:])
+	if f.liar {
+		for _, loc := range territory {
+			f.cells[loc.row][loc.col].lie = f.randomLie()
+		}
+	}
+
+	f.reset()
+	f.snapshotMu.Lock()
+	f.snapshot = nil
+	f.snapshotMu.Unlock()
+
+	return count, nil
+}
@@ -0,0 +1,89 @@
+package minesweeper_test
+
+import (
+	"testing"
+
+	"github.com/aryuuu/mines-party-server/minesweeper"
+)
+
+func TestFieldGrow(t *testing.T) {
+	field := minesweeper.NewFieldBuilder().
+		WithRow(10).
+		WithCol(10).
+		WithMinesCount(15).
+		Build()
+
+	if _, err := field.Grow(5, 10); err != minesweeper.ErrGameNotStarted {
+		t.Fatalf("expected ErrGameNotStarted before the first open, got %v", err)
+	}
+
+	if _, err := field.OpenCell(5, 5, "p1"); err != nil {
+		t.Fatalf("failed to open cell: %v", err)
+	}
+	remaining := field.GetRemainingMines()
+	version := field.Version()
+
+	mines, err := field.Grow(5, 10)
+	if err != nil {
+		t.Fatalf("failed to grow: %v", err)
+	}
+	if field.GetRow() != 15 || field.GetCol() != 20 {
+		t.Errorf("expected a 15x20 board, got %dx%d", field.GetRow(), field.GetCol())
+	}
+	if got := field.GetRemainingMines(); got != remaining+mines {
+		t.Errorf("expected %d remaining mines, got %d", remaining+mines, got)
+	}
+
+	// the new mines are only in the new territory
+	bare := *field.GetCellStringBare()
+	oldMines := 0
+	for i := 0; i < 10; i++ {
+		for j := 0; j < 10; j++ {
+			if bare[i][j] == "X" {
+				oldMines++
+			}
+		}
+	}
+	if oldMines != 15 {
+		t.Errorf("expected 15 mines in the old area, got %d", oldMines)
+	}
+
+	if _, ok := field.DiffSince(version); ok {
+		t.Errorf("expected no diff across a growth")
+	}
+	assertNumbersMatchMines(t, field)
+
+	if _, err := field.Grow(1000, 1000); err != minesweeper.ErrBoardTooLarge {
+		t.Errorf("expected ErrBoardTooLarge, got %v", err)
+	}
+	if _, err := field.Grow(0, 0); err != minesweeper.ErrEmptyGrowth {
+		t.Errorf("expected ErrEmptyGrowth, got %v", err)
+	}
+}
+
+func TestGameRoomGrowDefaults(t *testing.T) {
+	host := minesweeper.NewPlayer("host", "")
+	room := minesweeper.NewGameRoom("grow", host.PlayerID, 4)
+	room.AddPlayer(host)
+
+	// the grow settings left out fall back to the defaults
+	settings := room.GetSettings()
+	settings.Mode = minesweeper.MODE_EXPANDING
+	settings.GrowRows, settings.GrowCols = 0, 0
+	room.UpdateSettings(settings)
+	if err := room.Start(); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	if _, _, err := room.OpenCell(0, 0, host.PlayerID); err != nil && err != minesweeper.ErrOpenMine {
+		t.Fatalf("failed to open cell: %v", err)
+	}
+	before, _ := room.GetSnapshot()
+
+	if _, err := room.Grow(); err != nil {
+		t.Fatalf("failed to grow: %v", err)
+	}
+	after, _ := room.GetSnapshot()
+	if len(*after) != len(*before)+minesweeper.DEFAULT_GROW_ROWS || len((*after)[0]) != len((*before)[0])+minesweeper.DEFAULT_GROW_COLS {
+		t.Errorf("expected the default growth, got %dx%d from %dx%d", len(*after), len((*after)[0]), len(*before), len((*before)[0]))
+	}
+}
@@ -0,0 +1,67 @@
+package minesweeper
+
+const (
+	// FLAG_POLICY_ANYONE lets every player remove any flag
+	FLAG_POLICY_ANYONE = "anyone"
+	// FLAG_POLICY_OWNER only lets the player who placed a flag remove it
+	FLAG_POLICY_OWNER = "owner"
+	// FLAG_POLICY_TEAM lets the owner and their teammates remove a flag, the
+	// teams are assigned by the host, see GameRoom.AssignTeam
+	FLAG_POLICY_TEAM = "team"
+)
+
+// IsFlagPolicy tells whether the policy is one of the FLAG_POLICY_ constants,
+// an empty policy means FLAG_POLICY_ANYONE.
+func IsFlagPolicy(policy string) bool {
+	switch policy {
+	case "", FLAG_POLICY_ANYONE, FLAG_POLICY_OWNER, FLAG_POLICY_TEAM:
+		return true
+	}
+	return false
+}
+
+// flagRule decides who may remove a mark and whose flags count when chording.
+type flagRule struct {
+	flagPolicy string
+	// teamOf resolves the team of a player, an empty team means no team
+	teamOf func(playerID string) string
+}
+
+// canManageFlag tells whether the player may remove a mark placed by ownerID,
+// and whether the flags of ownerID count for the player when chording.
+func (r flagRule) canManageFlag(ownerID, playerID string) bool {
+	switch r.flagPolicy {
+	case FLAG_POLICY_OWNER:
+		return ownerID == playerID
+	case FLAG_POLICY_TEAM:
+		if ownerID == playerID {
+			return true
+		}
+		if r.teamOf == nil {
+			return false
+		}
+		team := r.teamOf(ownerID)
+		return team != "" && team == r.teamOf(playerID)
+	}
+	return true
+}
+
+// canMark tells whether the player may cycle the mark of the cell.
+func (r flagRule) canMark(cell *Cell, playerID string) bool {
+	if cell.isFlagged {
+		return r.canManageFlag(cell.flaggerID, playerID)
+	}
+	if cell.isQuestioned {
+		return r.canManageFlag(cell.questionerID, playerID)
+	}
+	return true
+}
+
+// countsAsFlag tells whether the cell is a known mine the player can rely on
+// when chording, either a flag or a mine opened earlier.
+func (r flagRule) countsAsFlag(cell *Cell, playerID string) bool {
+	if cell.isOpen && cell.isMine {
+		return true
+	}
+	return cell.isFlagged && r.canManageFlag(cell.flaggerID, playerID)
+}
@@ -1,6 +1,7 @@
 package minesweeper
 
 import (
+	"encoding/json"
 	"sync"
 	"time"
 
@@ -8,14 +9,117 @@ import (
 	"github.com/google/uuid"
 )
 
+const (
+	MODE_CLASSIC = "classic"
+	MODE_ENDLESS = "endless"
+	// MODE_SURVIVAL is a classic board where new mines are planted over time
+	MODE_SURVIVAL = "survival"
+	// MODE_EXPANDING is a classic board that grows instead of ending when it
+	// is cleared
+	MODE_EXPANDING = "expanding"
+)
+
+// IsMode tells whether the mode is one of the MODE_ constants.
+func IsMode(mode string) bool {
+	switch mode {
+	case MODE_CLASSIC, MODE_ENDLESS, MODE_SURVIVAL, MODE_EXPANDING:
+		return true
+	}
+	return false
+}
+
+const (
+	// MINE_HIT_END ends the game for everyone
+	MINE_HIT_END = "end"
+	// MINE_HIT_FREEZE keeps the offending player from acting for a while
+	MINE_HIT_FREEZE = "freeze"
+	// MINE_HIT_DEDUCT only costs the offending player the mine score
+	MINE_HIT_DEDUCT = "deduct"
+	// MINE_HIT_ELIMINATE takes the offending player out of the game
+	MINE_HIT_ELIMINATE = "eliminate"
+
+	DEFAULT_FREEZE_SECONDS = 10
+)
+
+// IsMineHitPolicy tells whether the policy is one of the MINE_HIT_ constants,
+// an empty policy means MINE_HIT_END.
+func IsMineHitPolicy(policy string) bool {
+	switch policy {
+	case "", MINE_HIT_END, MINE_HIT_FREEZE, MINE_HIT_DEDUCT, MINE_HIT_ELIMINATE:
+		return true
+	}
+	return false
+}
+
+// DEFAULT_SPECTATOR_CAPACITY is the number of spectators a room takes by
+// default
+const DEFAULT_SPECTATOR_CAPACITY = 8
+
+// MAX_CAPACITY and MAX_SPECTATOR_CAPACITY bound the capacities set by the
+// hosts
+const (
+	MAX_CAPACITY           = 32
+	MAX_SPECTATOR_CAPACITY = 64
+)
+
+const (
+	// VISIBILITY_PRIVATE rooms can only be joined with their ID
+	VISIBILITY_PRIVATE = "private"
+	// VISIBILITY_PUBLIC rooms are listed in the lobby
+	VISIBILITY_PUBLIC = "public"
+)
+
+// IsVisibility tells whether the visibility is one of the VISIBILITY_
+// constants, an empty visibility means VISIBILITY_PRIVATE.
+func IsVisibility(visibility string) bool {
+	switch visibility {
+	case "", VISIBILITY_PRIVATE, VISIBILITY_PUBLIC:
+		return true
+	}
+	return false
+}
+
+// Board is implemented by the playable boards of a room.
+type Board interface {
+	OpenCell(row, col int, playerID string) (int, error)
+	ToggleFlagCell(row, col int, playerID string) (*Cell, error)
+	IsCleared() bool
+	Version() uint64
+	DiffSince(version uint64) (*BoardDiff, bool)
+	GetSnapshot() (*[][]string, uint64)
+}
+
 type Player struct {
 	PlayerID   string       `json:"id_player,omitempty"`
 	Name       string       `json:"name,omitempty"`
 	Avatar     string       `json:"avatar,omitempty"`
 	IsHost     bool         `json:"is_host,omitempty"`
-	ScoreWLock sync.RWMutex `json:"_"`
+	ScoreWLock sync.RWMutex `json:"-"`
 	Score      int          `json:"score"`
 	Color      string       `json:"color"`
+	// Team is assigned by the host, see GameRoom.AssignTeam, it is guarded by
+	// ScoreWLock
+	Team string `json:"team,omitempty"`
+
+	// FrozenUntil and IsEliminated are the mine hit penalties, they are
+	// guarded by ScoreWLock as well
+	FrozenUntil  time.Time `json:"-"`
+	IsEliminated bool      `json:"-"`
+}
+
+// playerJSON mirrors Player without its locks, so it can be marshalled from
+// a consistent copy.
+type playerJSON struct {
+	PlayerID string `json:"id_player,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Avatar   string `json:"avatar,omitempty"`
+	IsHost   bool   `json:"is_host,omitempty"`
+	Score    int    `json:"score"`
+	Color    string `json:"color"`
+	Team     string `json:"team,omitempty"`
+	// FrozenUntil is in unix milliseconds
+	FrozenUntil  int64 `json:"frozen_until,omitempty"`
+	IsEliminated bool  `json:"is_eliminated,omitempty"`
 }
 
 func NewPlayer(name, avatar string) *Player {
@@ -34,6 +138,81 @@ func (p *Player) AddScore(val int) {
 	p.ScoreWLock.Unlock()
 }
 
+func (p *Player) GetScore() int {
+	p.ScoreWLock.RLock()
+	defer p.ScoreWLock.RUnlock()
+
+	return p.Score
+}
+
+func (p *Player) SetHost(val bool) {
+	p.ScoreWLock.Lock()
+	p.IsHost = val
+	p.ScoreWLock.Unlock()
+}
+
+// Freeze keeps the player from acting for the given duration and returns when
+// the player thaws.
+func (p *Player) Freeze(duration time.Duration) time.Time {
+	p.ScoreWLock.Lock()
+	defer p.ScoreWLock.Unlock()
+
+	p.FrozenUntil = time.Now().Add(duration)
+	return p.FrozenUntil
+}
+
+func (p *Player) Eliminate() {
+	p.ScoreWLock.Lock()
+	p.IsEliminated = true
+	p.ScoreWLock.Unlock()
+}
+
+// CanAct returns an error when the player is frozen or eliminated, along with
+// the time left before a frozen player can act again.
+func (p *Player) CanAct() (time.Duration, error) {
+	p.ScoreWLock.RLock()
+	defer p.ScoreWLock.RUnlock()
+
+	if p.IsEliminated {
+		return 0, ErrPlayerEliminated
+	}
+
+	if remaining := time.Until(p.FrozenUntil); remaining > 0 {
+		return remaining, ErrPlayerFrozen
+	}
+
+	return 0, nil
+}
+
+// resetPenalties clears the penalties of the previous game.
+func (p *Player) resetPenalties() {
+	p.ScoreWLock.Lock()
+	p.FrozenUntil = time.Time{}
+	p.IsEliminated = false
+	p.ScoreWLock.Unlock()
+}
+
+func (p *Player) MarshalJSON() ([]byte, error) {
+	p.ScoreWLock.RLock()
+	view := playerJSON{
+		PlayerID: p.PlayerID,
+		Name:     p.Name,
+		Avatar:   p.Avatar,
+		IsHost:   p.IsHost,
+		Score:    p.Score,
+		Color:    p.Color,
+		Team:     p.Team,
+
+		IsEliminated: p.IsEliminated,
+	}
+	if !p.FrozenUntil.IsZero() {
+		view.FrozenUntil = p.FrozenUntil.UnixMilli()
+	}
+	p.ScoreWLock.RUnlock()
+
+	return json.Marshal(view)
+}
+
 func randColor() string {
 	colors := []string{
 		"#8fbcbb",
@@ -50,27 +229,111 @@ func randColor() string {
 	return colors[utils.GenerateRandomInt(0, len(colors)-1)]
 }
 
-// GameRoom :nodoc:
+// GameRoom is safe for concurrent use. mu guards the room state (players,
+// ballot, settings and lifecycle) while FieldWLoc serializes every action on
+// the field, so that compound actions on the board are applied one at a time.
+// When both are needed FieldWLoc must be acquired first.
 type GameRoom struct {
+	mu sync.RWMutex
+
 	RoomID     string             `json:"id_room,omitempty"`
 	IsStarted  bool               `json:"is_started,omitempty"`
 	Players    map[string]*Player `json:"players"`
-	VoteBallot map[string]int     `json:"-"`
+	Spectators map[string]*Player `json:"spectators"`
 	Settings   Settings           `json:"settings"`
+	// VoteBallot holds the vote of each player against the players facing a
+	// vote kick
+	VoteBallot map[string]map[string]bool `json:"-"`
+
+	// banned holds the names of the players kicked out of the room
+	banned map[string]bool
 
 	FieldWLoc sync.RWMutex `json:"-"`
 	Field     *Field       `json:"-"`
+	// Endless is the board of the running game in endless mode, Field is left
+	// empty in that case
+	Endless *EndlessField `json:"-"`
 
 	ScoreTicker *time.Ticker `json:"-"`
+	// RoomTicker drives the timed events of the game, such as the mine waves
+	// of survival mode
+	RoomTicker   *time.Ticker  `json:"-"`
+	roomTickDone chan struct{} `json:"-"`
+}
+
+// gameRoomJSON is the wire representation of GameRoom.
+type gameRoomJSON struct {
+	RoomID     string             `json:"id_room,omitempty"`
+	IsStarted  bool               `json:"is_started,omitempty"`
+	Players    map[string]*Player `json:"players"`
+	Spectators map[string]*Player `json:"spectators"`
+	Settings   Settings           `json:"settings"`
 }
 
 type Settings struct {
-	Capacity      int    `json:"capacity"`
+	// Capacity is the maximum number of players, SpectatorCapacity the
+	// maximum number of spectators, 0 means no limit
+	Capacity          int `json:"capacity"`
+	SpectatorCapacity int `json:"spectator_capacity"`
+
 	HostID        string `json:"id_host"`
 	Difficulty    string `json:"difficulty"`
 	CellScore     int    `json:"cell_score"`
 	MineScore     int    `json:"mine_score"`
 	CountColdOpen bool   `json:"count_cold_open"`
+	Mode          string `json:"mode"`
+	// QuestionMarks adds a question mark state when cycling the cell marks
+	QuestionMarks bool `json:"question_marks"`
+	// FlagPolicy decides who can remove a flag, see FLAG_POLICY_ANYONE
+	FlagPolicy string `json:"flag_policy"`
+	// FlagBudget rejects new flags once there are as many flags as mines
+	FlagBudget bool `json:"flag_budget"`
+	// MineHitPolicy decides what happens when a mine is opened, see
+	// MINE_HIT_END
+	MineHitPolicy string `json:"mine_hit_policy"`
+	// FreezeSeconds is how long a player is frozen with MINE_HIT_FREEZE,
+	// DEFAULT_FREEZE_SECONDS when not set
+	FreezeSeconds int `json:"freeze_seconds"`
+	// ScoringPolicy decides how the actions are scored, see SCORING_CLASSIC
+	ScoringPolicy string `json:"scoring_policy"`
+	// SpawnSeconds is the time between two mine waves in survival mode
+	SpawnSeconds int `json:"spawn_seconds"`
+	// SpawnCount is the number of mines planted by a wave in survival mode,
+	// DEFAULT_SPAWN_COUNT when not set
+	SpawnCount int `json:"spawn_count"`
+	// GrowRows and GrowCols are appended to the board by a growth in
+	// expanding mode, DEFAULT_GROW_ROWS and DEFAULT_GROW_COLS when not set
+	GrowRows int `json:"grow_rows"`
+	GrowCols int `json:"grow_cols"`
+	// GrowSeconds also grows the board at this interval in expanding mode, 0
+	// only grows the board when it is cleared
+	GrowSeconds int `json:"grow_seconds"`
+	// FirstClickPolicy decides which cells are kept free of mines around the
+	// first click, see FIRST_CLICK_ZERO
+	FirstClickPolicy string `json:"first_click_policy"`
+	// Liar shows every number off by one, Liar and NoGuess apply to the
+	// classic boards
+	Liar bool `json:"liar"`
+	// NoGuess only lays out boards that can be solved without guessing
+	NoGuess bool `json:"no_guess"`
+	// TreasureRatio is the fraction of the safe cells hiding a treasure worth
+	// one of TreasureValues, 0 disables the treasures
+	TreasureRatio  float64 `json:"treasure_ratio"`
+	TreasureValues []int   `json:"treasure_values,omitempty"`
+	// Seed of the endless board, a random one is used when empty
+	Seed int64 `json:"seed,omitempty"`
+	// Password is required to join the room, it is never sent back to the
+	// clients, see Settings.MarshalJSON. A nil password keeps the current
+	// one on update and an empty one removes it.
+	Password *string `json:"password,omitempty"`
+	// HasPassword tells the clients whether the room needs a password, it is
+	// ignored on update
+	HasPassword bool `json:"has_password"`
+	// InviteOnly only lets in the clients holding an invite from the host
+	InviteOnly bool `json:"invite_only"`
+	// Visibility decides whether the room is listed in the lobby, see
+	// VISIBILITY_PRIVATE
+	Visibility string `json:"visibility"`
 }
 
 func NewGameRoom(roomID string, hostID string, capacity int) *GameRoom {
@@ -78,78 +341,676 @@ func NewGameRoom(roomID string, hostID string, capacity int) *GameRoom {
 		RoomID:     roomID,
 		IsStarted:  false,
 		Players:    map[string]*Player{},
-		VoteBallot: map[string]int{},
+		Spectators: map[string]*Player{},
+		VoteBallot: map[string]map[string]bool{},
+		banned:     map[string]bool{},
 		Field:      &Field{},
 		Settings: Settings{
-			Capacity:      capacity,
-			HostID:        hostID,
-			CellScore:     DEFAULT_CELL_POINT,
-			MineScore:     DEFAULT_MINE_POINT,
-			CountColdOpen: false,
+			Capacity:          capacity,
+			SpectatorCapacity: DEFAULT_SPECTATOR_CAPACITY,
+			HostID:            hostID,
+			CellScore:         DEFAULT_CELL_POINT,
+			MineScore:         DEFAULT_MINE_POINT,
+			CountColdOpen:     false,
+			Mode:              MODE_CLASSIC,
+			FlagPolicy:        FLAG_POLICY_ANYONE,
+			MineHitPolicy:     MINE_HIT_END,
+			FreezeSeconds:     DEFAULT_FREEZE_SECONDS,
+			ScoringPolicy:     SCORING_CLASSIC,
+			SpawnSeconds:      DEFAULT_SPAWN_SECONDS,
+			SpawnCount:        DEFAULT_SPAWN_COUNT,
+			GrowRows:          DEFAULT_GROW_ROWS,
+			GrowCols:          DEFAULT_GROW_COLS,
+			TreasureValues:    append([]int{}, DEFAULT_TREASURE_VALUES...),
+			FirstClickPolicy:  FIRST_CLICK_ZERO,
+			Visibility:        VISIBILITY_PRIVATE,
 		},
 	}
 }
 
+func (gr *GameRoom) MarshalJSON() ([]byte, error) {
+	gr.mu.RLock()
+	spectators := make(map[string]*Player, len(gr.Spectators))
+	for id, spectator := range gr.Spectators {
+		spectators[id] = spectator
+	}
+	view := gameRoomJSON{
+		RoomID:     gr.RoomID,
+		IsStarted:  gr.IsStarted,
+		Players:    gr.copyPlayers(),
+		Spectators: spectators,
+		Settings:   gr.Settings,
+	}
+	gr.mu.RUnlock()
+
+	return json.Marshal(view)
+}
+
 func (gr *GameRoom) IsEmpty() bool {
-	return len(gr.Players) == 0
+	gr.mu.RLock()
+	defer gr.mu.RUnlock()
+
+	return len(gr.Players) == 0 && len(gr.Spectators) == 0
+}
+
+func (gr *GameRoom) PlayerCount() int {
+	gr.mu.RLock()
+	defer gr.mu.RUnlock()
+
+	return len(gr.Players)
+}
+
+func (gr *GameRoom) SpectatorCount() int {
+	gr.mu.RLock()
+	defer gr.mu.RUnlock()
+
+	return len(gr.Spectators)
+}
+
+// IsPublic tells whether the room is listed in the lobby, invite only rooms
+// never are.
+func (gr *GameRoom) IsPublic() bool {
+	gr.mu.RLock()
+	defer gr.mu.RUnlock()
+
+	return gr.Settings.Visibility == VISIBILITY_PUBLIC && !gr.Settings.InviteOnly
+}
+
+func (gr *GameRoom) HasStarted() bool {
+	gr.mu.RLock()
+	defer gr.mu.RUnlock()
+
+	return gr.IsStarted
 }
 
 func (gr *GameRoom) IsUsernameExist(username string) bool {
+	gr.mu.RLock()
+	defer gr.mu.RUnlock()
+
+	return gr.isUsernameExist(username)
+}
+
+func (gr *GameRoom) isUsernameExist(username string) bool {
 	for _, player := range gr.Players {
 		if player.Name == username {
 			return true
 		}
 	}
+	for _, spectator := range gr.Spectators {
+		if spectator.Name == username {
+			return true
+		}
+	}
 
 	return false
 }
 
 func (gr *GameRoom) PickRandomHost() string {
+	gr.mu.Lock()
+	defer gr.mu.Unlock()
+
 	for id := range gr.Players {
-		gr.Players[id].IsHost = true
+		gr.Players[id].SetHost(true)
 		gr.Settings.HostID = id
 		return id
 	}
+	gr.Settings.HostID = ""
 	return ""
 }
 
+// GetSettings returns a copy of the room settings.
+func (gr *GameRoom) GetSettings() Settings {
+	gr.mu.RLock()
+	defer gr.mu.RUnlock()
+
+	return gr.Settings
+}
+
+// UpdateSettings replaces the configurable settings, leaving the host as is,
+// as well as the password when none is given.
+func (gr *GameRoom) UpdateSettings(settings Settings) {
+	gr.mu.Lock()
+	defer gr.mu.Unlock()
+
+	settings.HostID = gr.Settings.HostID
+	if settings.Password == nil {
+		settings.Password = gr.Settings.Password
+	} else if *settings.Password == "" {
+		settings.Password = nil
+	}
+	gr.Settings = settings
+}
+
+func (gr *GameRoom) IsHost(playerID string) bool {
+	gr.mu.RLock()
+	defer gr.mu.RUnlock()
+
+	// the rooms opened by the matchmaking have no host until a player joins,
+	// the connections outside of the room have no ID either
+	return playerID != "" && gr.Settings.HostID == playerID
+}
+
 func (gr *GameRoom) Start() error {
+	gr.FieldWLoc.Lock()
+	defer gr.FieldWLoc.Unlock()
+	gr.mu.Lock()
+	defer gr.mu.Unlock()
+
+	for _, player := range gr.Players {
+		player.resetPenalties()
+	}
+
+	if gr.Settings.Mode == MODE_ENDLESS {
+		gr.Field = &Field{}
+		gr.Endless = NewEndlessFieldBuilder().
+			WithSeed(gr.Settings.Seed).
+			WithDifficulty(gr.Settings.Difficulty).
+			WithCellScore(gr.Settings.CellScore).
+			WithMineScore(gr.Settings.MineScore).
+			WithScoringPolicy(gr.Settings.ScoringPolicy).
+			WithFirstClickPolicy(gr.Settings.FirstClickPolicy).
+			WithQuestionMarks(gr.Settings.QuestionMarks).
+			WithFlagPolicy(gr.Settings.FlagPolicy).
+			WithTeamResolver(gr.getTeam).
+			Build()
+		gr.IsStarted = true
+
+		return nil
+	}
+
+	gr.Endless = nil
 	gr.Field = NewFieldBuilder().
 		WithDifficulty(gr.Settings.Difficulty).
 		WithCellScore(gr.Settings.CellScore).
 		WithMineScore(gr.Settings.MineScore).
 		WithCountColdOpen(gr.Settings.CountColdOpen).
+		WithScoringPolicy(gr.Settings.ScoringPolicy).
+		WithSurvival(gr.Settings.Mode == MODE_SURVIVAL).
+		WithTreasures(gr.Settings.TreasureRatio, gr.Settings.TreasureValues).
+		WithFirstClickPolicy(gr.Settings.FirstClickPolicy).
+		WithLiar(gr.Settings.Liar).
+		WithNoGuess(gr.Settings.NoGuess).
+		WithQuestionMarks(gr.Settings.QuestionMarks).
+		WithFlagPolicy(gr.Settings.FlagPolicy).
+		WithFlagBudget(gr.Settings.FlagBudget).
+		WithTeamResolver(gr.getTeam).
 		Build()
 	gr.IsStarted = true
 
 	return nil
 }
 
+// End stops the running game. Only the first of several concurrent callers
+// succeeds, the others get ErrGameNotStarted.
 func (gr *GameRoom) End() error {
+	gr.mu.Lock()
+	defer gr.mu.Unlock()
+
+	if !gr.IsStarted {
+		return ErrGameNotStarted
+	}
+
 	gr.IsStarted = false
 	if gr.ScoreTicker != nil {
 		gr.ScoreTicker.Stop()
 	}
+	gr.stopRoomTicker()
 
 	return nil
 }
 
+// SetRoomTicker replaces the ticker driving the timed events of the game and
+// returns a channel that is closed once the game ends or the ticker is
+// replaced, at which point the loop reading the ticker should return.
+func (gr *GameRoom) SetRoomTicker(ticker *time.Ticker) <-chan struct{} {
+	gr.mu.Lock()
+	defer gr.mu.Unlock()
+
+	gr.stopRoomTicker()
+	gr.RoomTicker = ticker
+	gr.roomTickDone = make(chan struct{})
+
+	return gr.roomTickDone
+}
+
+func (gr *GameRoom) stopRoomTicker() {
+	if gr.RoomTicker != nil {
+		gr.RoomTicker.Stop()
+		gr.RoomTicker = nil
+	}
+	if gr.roomTickDone != nil {
+		close(gr.roomTickDone)
+		gr.roomTickDone = nil
+	}
+}
+
+// SetScoreTicker replaces the ticker used by the score cron, stopping the
+// previous one if any.
+func (gr *GameRoom) SetScoreTicker(ticker *time.Ticker) {
+	gr.mu.Lock()
+	defer gr.mu.Unlock()
+
+	if gr.ScoreTicker != nil {
+		gr.ScoreTicker.Stop()
+	}
+	gr.ScoreTicker = ticker
+}
+
+// getTeam returns the team of the player, it is called by the board while
+// FieldWLoc is held.
+func (gr *GameRoom) getTeam(playerID string) string {
+	player, ok := gr.GetPlayer(playerID)
+	if !ok {
+		return ""
+	}
+	player.ScoreWLock.RLock()
+	defer player.ScoreWLock.RUnlock()
+	return player.Team
+}
+
 func (r *GameRoom) AddPlayer(player *Player) {
+	r.mu.Lock()
 	r.Players[player.PlayerID] = player
+	r.mu.Unlock()
 }
 
+// Join admits the player into the room, or as a spectator, when the room has
+// room for it. The first player of a room without host becomes its host.
+func (r *GameRoom) Join(player *Player, spectate bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.banned[player.Name] {
+		return ErrBanned
+	}
+	if r.isUsernameExist(player.Name) {
+		return ErrNameTaken
+	}
+
+	if spectate {
+		if isFull(len(r.Spectators), r.Settings.SpectatorCapacity) {
+			return ErrSpectatorsFull
+		}
+		r.Spectators[player.PlayerID] = player
+		return nil
+	}
+
+	if r.IsStarted {
+		return ErrGameStarted
+	}
+	if isFull(len(r.Players), r.Settings.Capacity) {
+		return ErrRoomFull
+	}
+	r.Players[player.PlayerID] = player
+	if _, ok := r.Players[r.Settings.HostID]; !ok {
+		player.SetHost(true)
+		r.Settings.HostID = player.PlayerID
+	}
+	return nil
+}
+
+func isFull(count, capacity int) bool {
+	return capacity > 0 && count >= capacity
+}
+
+// Ban keeps players with the given name from joining the room again.
+func (r *GameRoom) Ban(name string) {
+	r.mu.Lock()
+	r.banned[name] = true
+	r.mu.Unlock()
+}
+
+// RemovePlayer removes the player, or the spectator, from the room.
 func (r *GameRoom) RemovePlayer(id string) {
+	r.mu.Lock()
 	delete(r.Players, id)
+	delete(r.Spectators, id)
+	r.mu.Unlock()
+}
+
+// AddSpectator lets the player watch the games without playing, spectators do
+// not count toward the capacity of the room nor the vote kick majorities.
+func (r *GameRoom) AddSpectator(spectator *Player) {
+	r.mu.Lock()
+	r.Spectators[spectator.PlayerID] = spectator
+	r.mu.Unlock()
+}
+
+func (r *GameRoom) IsSpectator(id string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	_, ok := r.Spectators[id]
+	return ok
+}
+
+// GetMember returns the player or the spectator with the given ID.
+func (r *GameRoom) GetMember(id string) (*Player, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if player, ok := r.Players[id]; ok {
+		return player, true
+	}
+	spectator, ok := r.Spectators[id]
+	return spectator, ok
+}
+
+// PromoteSpectator turns the spectator into a player, between games only. The
+// spectator becomes the host when the room has none.
+func (r *GameRoom) PromoteSpectator(id string) (*Player, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	spectator, ok := r.Spectators[id]
+	if !ok {
+		return nil, ErrNotSpectator
+	}
+	if r.IsStarted {
+		return nil, ErrGameStarted
+	}
+	if isFull(len(r.Players), r.Settings.Capacity) {
+		return nil, ErrRoomFull
+	}
+
+	delete(r.Spectators, id)
+	r.Players[id] = spectator
+	if _, ok := r.Players[r.Settings.HostID]; !ok {
+		spectator.SetHost(true)
+		r.Settings.HostID = id
+	}
+	return spectator, nil
+}
+
+// AssignTeam puts a player in a team, an empty team takes it out of its team.
+// The teams cannot change while the game is running.
+func (r *GameRoom) AssignTeam(id, team string) (*Player, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	player, ok := r.Players[id]
+	if !ok {
+		return nil, ErrNotPlayer
+	}
+	if r.IsStarted {
+		return nil, ErrGameStarted
+	}
+
+	player.ScoreWLock.Lock()
+	player.Team = team
+	player.ScoreWLock.Unlock()
+	return player, nil
+}
+
+func (r *GameRoom) GetPlayer(id string) (*Player, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	player, ok := r.Players[id]
+	return player, ok
+}
+
+// GetPlayers returns a copy of the players map that is safe to iterate and
+// hand over to other goroutines.
+func (r *GameRoom) GetPlayers() map[string]*Player {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.copyPlayers()
+}
+
+func (r *GameRoom) copyPlayers() map[string]*Player {
+	result := make(map[string]*Player, len(r.Players))
+	for id, player := range r.Players {
+		result[id] = player
+	}
+	return result
+}
+
+// Scoreboard returns the current score of every player.
+func (r *GameRoom) Scoreboard() map[string]int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := make(map[string]int, len(r.Players))
+	for id, player := range r.Players {
+		result[id] = player.GetScore()
+	}
+	return result
+}
+
+// HasActivePlayers tells whether at least one player has not been
+// eliminated.
+func (r *GameRoom) HasActivePlayers() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, player := range r.Players {
+		if _, err := player.CanAct(); err != ErrPlayerEliminated {
+			return true
+		}
+	}
+	return false
+}
+
+// OpenBallot starts a vote kick against the given player, a ballot already
+// open keeps its votes.
+func (r *GameRoom) OpenBallot(playerID string) {
+	r.mu.Lock()
+	if _, ok := r.VoteBallot[playerID]; !ok {
+		r.VoteBallot[playerID] = map[string]bool{}
+	}
+	r.mu.Unlock()
+}
+
+// CastVote records the vote of the voter against the given player, replacing
+// its previous vote. It returns the current tally, whether the majority has
+// been reached (closing the ballot if so) and whether the vote counted at
+// all: a ballot must be open and only the players can vote.
+func (r *GameRoom) CastVote(playerID string, voterID string, agree bool) (int, bool, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	votes, ok := r.VoteBallot[playerID]
+	if _, isPlayer := r.Players[voterID]; !ok || !isPlayer {
+		return 0, false, false
+	}
+
+	votes[voterID] = agree
+	tally := 0
+	for id, vote := range votes {
+		// the players who left since do not count
+		if _, ok := r.Players[id]; ok && vote {
+			tally++
+		}
+	}
+
+	passed := agree && tally > len(r.Players)/2
+	if passed {
+		delete(r.VoteBallot, playerID)
+	}
+
+	return tally, passed, true
+}
+
+// GetField returns the field of the current (or last) game.
+func (r *GameRoom) GetField() *Field {
+	r.FieldWLoc.RLock()
+	defer r.FieldWLoc.RUnlock()
+
+	return r.Field
+}
+
+// GetEndless returns the endless board of the current (or last) game, if the
+// game was played in endless mode.
+func (r *GameRoom) GetEndless() (*EndlessField, bool) {
+	r.FieldWLoc.RLock()
+	defer r.FieldWLoc.RUnlock()
+
+	return r.Endless, r.Endless != nil
+}
+
+func (r *GameRoom) GetBoard() Board {
+	r.FieldWLoc.RLock()
+	defer r.FieldWLoc.RUnlock()
+
+	return r.board()
+}
+
+// board returns the board in play, FieldWLoc must be held.
+func (r *GameRoom) board() Board {
+	if r.Endless != nil {
+		return r.Endless
+	}
+	return r.Field
+}
+
+// OpenCell opens the cell at the given position and returns the points
+// earned along with the cells that changed.
+func (r *GameRoom) OpenCell(row, col int, playerID string) (int, *BoardDiff, error) {
+	r.FieldWLoc.Lock()
+	defer r.FieldWLoc.Unlock()
+
+	if !r.HasStarted() {
+		return 0, nil, ErrGameNotStarted
+	}
+
+	board := r.board()
+	version := board.Version()
+	points, err := board.OpenCell(row, col, playerID)
+	diff, _ := board.DiffSince(version)
+	return points, diff, err
 }
 
-func (r *GameRoom) OpenCell(row, col int, playerID string) (int, error) {
+// FlagCell toggles the flag on the cell at the given position and returns the
+// cells that changed.
+func (r *GameRoom) FlagCell(row, col int, playerID string) (*BoardDiff, error) {
 	r.FieldWLoc.Lock()
-	points, err := r.Field.OpenCell(row, col, playerID)
-	r.FieldWLoc.Unlock()
-	return points, err
+	defer r.FieldWLoc.Unlock()
+
+	if !r.HasStarted() {
+		return nil, ErrGameNotStarted
+	}
+
+	board := r.board()
+	version := board.Version()
+	_, err := board.ToggleFlagCell(row, col, playerID)
+	diff, _ := board.DiffSince(version)
+	return diff, err
+}
+
+func (r *GameRoom) GetCellString() *[][]string {
+	return r.GetField().GetCellString()
 }
 
-func (r *GameRoom) FlagCell(row, col int, playerID string) error {
-	_, err := r.Field.ToggleFlagCell(row, col, playerID)
-	return err
+func (r *GameRoom) GetCellStringBare() *[][]string {
+	return r.GetField().GetCellStringBare()
+}
+
+func (r *GameRoom) IsCleared() bool {
+	return r.GetBoard().IsCleared()
+}
+
+func (r *GameRoom) GetSnapshot() (*[][]string, uint64) {
+	return r.GetBoard().GetSnapshot()
+}
+
+func (r *GameRoom) DiffSince(version uint64) (*BoardDiff, bool) {
+	return r.GetBoard().DiffSince(version)
+}
+
+// GetRemainingMines returns the mines count minus the flags, endless boards
+// have no such counter.
+func (r *GameRoom) GetRemainingMines() (int, bool) {
+	r.FieldWLoc.RLock()
+	defer r.FieldWLoc.RUnlock()
+
+	if r.Endless != nil {
+		return 0, false
+	}
+	return r.Field.GetRemainingMines(), true
+}
+
+// SpawnMines plants a wave of mines on the survival board and returns the
+// number of mines planted along with the cells that changed.
+func (r *GameRoom) SpawnMines() (int, *BoardDiff, error) {
+	r.FieldWLoc.Lock()
+	defer r.FieldWLoc.Unlock()
+
+	if !r.HasStarted() {
+		return 0, nil, ErrGameNotStarted
+	}
+
+	settings := r.GetSettings()
+	if settings.Mode != MODE_SURVIVAL {
+		return 0, nil, ErrNotSurvival
+	}
+
+	count := settings.SpawnCount
+	if count <= 0 {
+		count = DEFAULT_SPAWN_COUNT
+	}
+	version := r.Field.Version()
+	planted := r.Field.SpawnMines(count, DEFAULT_SPAWN_SAFE_RADIUS)
+	diff, _ := r.Field.DiffSince(version)
+	return planted, diff, nil
+}
+
+// GetTreasureClaims returns the treasures opened so far, there are none on
+// endless boards.
+func (r *GameRoom) GetTreasureClaims() []TreasureClaim {
+	r.FieldWLoc.RLock()
+	defer r.FieldWLoc.RUnlock()
+
+	if r.Endless != nil {
+		return nil
+	}
+	return r.Field.GetTreasureClaims()
+}
+
+// Grow grows the expanding board and returns the number of mines laid in the
+// new territory.
+func (r *GameRoom) Grow() (int, error) {
+	return r.grow(false)
+}
+
+// GrowIfCleared grows the expanding board only if it is cleared, so that
+// concurrent last sweeps grow it once. It returns ErrNotCleared otherwise.
+func (r *GameRoom) GrowIfCleared() (int, error) {
+	return r.grow(true)
+}
+
+func (r *GameRoom) grow(onlyIfCleared bool) (int, error) {
+	r.FieldWLoc.Lock()
+	defer r.FieldWLoc.Unlock()
+
+	if !r.HasStarted() {
+		return 0, ErrGameNotStarted
+	}
+
+	settings := r.GetSettings()
+	if settings.Mode != MODE_EXPANDING {
+		return 0, ErrNotExpanding
+	}
+
+	if onlyIfCleared && !r.Field.IsCleared() {
+		return 0, ErrNotCleared
+	}
+
+	rows, cols := settings.GrowRows, settings.GrowCols
+	if rows <= 0 {
+		rows = DEFAULT_GROW_ROWS
+	}
+	if cols <= 0 {
+		cols = DEFAULT_GROW_COLS
+	}
+	return r.Field.Grow(rows, cols)
+}
+
+func (r *GameRoom) GetChunkWindow(window ChunkWindow) ([]ChunkView, uint64, error) {
+	endless, ok := r.GetEndless()
+	if !ok {
+		return nil, 0, ErrNotEndless
+	}
+	return endless.GetChunkWindow(window)
 }
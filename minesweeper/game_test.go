@@ -0,0 +1,300 @@
+package minesweeper_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aryuuu/mines-party-server/minesweeper"
+)
+
+// TestGameRoomConcurrentPlayers hammers a single room from many goroutines,
+// run it with -race to catch unsynchronized access.
+func TestGameRoomConcurrentPlayers(t *testing.T) {
+	const (
+		playerCount      = 32
+		actionsPerPlayer = 300
+	)
+
+	room := minesweeper.NewGameRoom("stress", "", playerCount)
+	players := make([]*minesweeper.Player, playerCount)
+	for i := range players {
+		players[i] = minesweeper.NewPlayer(fmt.Sprintf("player-%d", i), "")
+		room.AddPlayer(players[i])
+	}
+	room.UpdateSettings(minesweeper.Settings{
+		Capacity:   playerCount,
+		Difficulty: "hard",
+		CellScore:  minesweeper.DEFAULT_CELL_POINT,
+		MineScore:  minesweeper.DEFAULT_MINE_POINT,
+	})
+	if err := room.Start(); err != nil {
+		t.Fatalf("failed to start game: %v", err)
+	}
+
+	row, col := room.GetField().GetRow(), room.GetField().GetCol()
+
+	var wg sync.WaitGroup
+	for i, player := range players {
+		wg.Add(1)
+		go func(seed int64, player *minesweeper.Player) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(seed))
+
+			for j := 0; j < actionsPerPlayer; j++ {
+				r, c := rng.Intn(row), rng.Intn(col)
+				switch rng.Intn(6) {
+				case 0:
+					points, _, _ := room.OpenCell(r, c, player.PlayerID)
+					player.AddScore(points)
+				case 1:
+					room.FlagCell(r, c, player.PlayerID)
+				case 2:
+					room.GetCellString()
+					room.IsCleared()
+				case 3:
+					if _, err := json.Marshal(room); err != nil {
+						t.Errorf("failed to marshal room: %v", err)
+					}
+				case 4:
+					room.Scoreboard()
+					room.GetPlayers()
+				case 5:
+					room.OpenBallot(player.PlayerID)
+					room.CastVote(player.PlayerID, player.PlayerID, rng.Intn(2) == 0)
+				}
+			}
+		}(int64(i), player)
+	}
+
+	// players coming and going while the game is running
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < actionsPerPlayer; i++ {
+			guest := minesweeper.NewPlayer(fmt.Sprintf("guest-%d", i), "")
+			room.AddPlayer(guest)
+			room.IsUsernameExist(guest.Name)
+			room.RemovePlayer(guest.PlayerID)
+			room.PickRandomHost()
+		}
+	}()
+
+	wg.Wait()
+	room.End()
+
+	if got := room.PlayerCount(); got != playerCount {
+		t.Errorf("expected %d players after the run, got %d", playerCount, got)
+	}
+
+	board := *room.GetCellString()
+	if len(board) != row || len(board[0]) != col {
+		t.Errorf("expected a %dx%d board, got %dx%d", row, col, len(board), len(board[0]))
+	}
+}
+
+func TestPlayerPenalties(t *testing.T) {
+	player := minesweeper.NewPlayer("alice", "")
+
+	if _, err := player.CanAct(); err != nil {
+		t.Fatalf("expected a fresh player to act, got %v", err)
+	}
+
+	player.Freeze(time.Minute)
+	remaining, err := player.CanAct()
+	if err != minesweeper.ErrPlayerFrozen {
+		t.Errorf("expected ErrPlayerFrozen, got %v", err)
+	}
+	if remaining <= 0 || remaining > time.Minute {
+		t.Errorf("expected a remaining freeze within a minute, got %v", remaining)
+	}
+
+	player.Eliminate()
+	if _, err := player.CanAct(); err != minesweeper.ErrPlayerEliminated {
+		t.Errorf("expected ErrPlayerEliminated, got %v", err)
+	}
+}
+
+func TestSpectators(t *testing.T) {
+	host := minesweeper.NewPlayer("host", "")
+	room := minesweeper.NewGameRoom("watch", host.PlayerID, 2)
+	room.AddPlayer(host)
+
+	spectators := []*minesweeper.Player{
+		minesweeper.NewPlayer("screen", ""),
+		minesweeper.NewPlayer("boss", ""),
+	}
+	for _, spectator := range spectators {
+		room.AddSpectator(spectator)
+	}
+
+	if room.PlayerCount() != 1 {
+		t.Errorf("expected spectators not to count as players, got %d players", room.PlayerCount())
+	}
+	if !room.IsUsernameExist("screen") {
+		t.Errorf("expected the spectator names to be taken")
+	}
+
+	// the host alone is the majority
+	room.OpenBallot(host.PlayerID)
+	if _, _, ok := room.CastVote(host.PlayerID, spectators[0].PlayerID, true); ok {
+		t.Errorf("expected spectators not to vote")
+	}
+	if _, passed, _ := room.CastVote(host.PlayerID, host.PlayerID, true); !passed {
+		t.Errorf("expected spectators not to count toward the majority")
+	}
+
+	payload, err := json.Marshal(room)
+	if err != nil {
+		t.Fatalf("failed to marshal the room: %v", err)
+	}
+	var view struct {
+		Spectators map[string]*minesweeper.Player `json:"spectators"`
+	}
+	json.Unmarshal(payload, &view)
+	if len(view.Spectators) != 2 {
+		t.Errorf("expected 2 spectators in the payload, got %s", payload)
+	}
+
+	if _, err := room.PromoteSpectator(host.PlayerID); err != minesweeper.ErrNotSpectator {
+		t.Errorf("expected ErrNotSpectator, got %v", err)
+	}
+	if _, err := room.PromoteSpectator(spectators[0].PlayerID); err != nil {
+		t.Fatalf("failed to promote: %v", err)
+	}
+	if room.IsSpectator(spectators[0].PlayerID) || room.PlayerCount() != 2 {
+		t.Errorf("expected the spectator to be a player")
+	}
+	if _, err := room.PromoteSpectator(spectators[1].PlayerID); err != minesweeper.ErrRoomFull {
+		t.Errorf("expected ErrRoomFull, got %v", err)
+	}
+
+	room.RemovePlayer(spectators[0].PlayerID)
+	if err := room.Start(); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	if _, err := room.PromoteSpectator(spectators[1].PlayerID); err != minesweeper.ErrGameStarted {
+		t.Errorf("expected ErrGameStarted, got %v", err)
+	}
+	room.End()
+
+	// a spectator left alone takes over the room
+	room.RemovePlayer(host.PlayerID)
+	if room.IsEmpty() {
+		t.Errorf("expected a room with a spectator not to be empty")
+	}
+	if _, err := room.PromoteSpectator(spectators[1].PlayerID); err != nil {
+		t.Fatalf("failed to promote: %v", err)
+	}
+	if !room.IsHost(spectators[1].PlayerID) {
+		t.Errorf("expected the promoted spectator to host the room")
+	}
+}
+
+func TestVoteKick(t *testing.T) {
+	host := minesweeper.NewPlayer("host", "")
+	room := minesweeper.NewGameRoom("vote", host.PlayerID, 4)
+	room.AddPlayer(host)
+	alice := minesweeper.NewPlayer("alice", "")
+	bob := minesweeper.NewPlayer("bob", "")
+	room.AddPlayer(alice)
+	room.AddPlayer(bob)
+
+	// a player votes once however many times it asks
+	room.OpenBallot(host.PlayerID)
+	for i := 0; i < 3; i++ {
+		if tally, passed, _ := room.CastVote(host.PlayerID, alice.PlayerID, true); tally != 1 || passed {
+			t.Fatalf("expected a single vote, got %d %v", tally, passed)
+		}
+	}
+	room.OpenBallot(host.PlayerID)
+	if tally, passed, _ := room.CastVote(host.PlayerID, bob.PlayerID, true); tally != 2 || !passed {
+		t.Errorf("expected the majority to pass the vote, got %d %v", tally, passed)
+	}
+}
+
+func TestGameRoomJoin(t *testing.T) {
+	room := minesweeper.NewGameRoom("join", "", 2)
+	settings := room.GetSettings()
+	settings.SpectatorCapacity = 1
+	room.UpdateSettings(settings)
+
+	cases := []struct {
+		name     string
+		spectate bool
+		err      error
+	}{
+		{"alice", false, nil},
+		{"alice", true, minesweeper.ErrNameTaken},
+		{"bob", false, nil},
+		{"carol", false, minesweeper.ErrRoomFull},
+		{"carol", true, nil},
+		{"dave", true, minesweeper.ErrSpectatorsFull},
+	}
+	for _, c := range cases {
+		if err := room.Join(minesweeper.NewPlayer(c.name, ""), c.spectate); err != c.err {
+			t.Errorf("expected %s (spectate %v) to get %v, got %v", c.name, c.spectate, c.err, err)
+		}
+	}
+	// the room had no host, the first player takes it
+	if host, ok := room.GetPlayer(room.GetSettings().HostID); !ok || host.Name != "alice" || !host.IsHost {
+		t.Errorf("expected alice to host the room, got %v", host)
+	}
+
+	room.Ban("mallory")
+	if err := room.Join(minesweeper.NewPlayer("mallory", ""), true); err != minesweeper.ErrBanned {
+		t.Errorf("expected ErrBanned, got %v", err)
+	}
+
+	settings.Capacity = 0
+	room.UpdateSettings(settings)
+	if err := room.Join(minesweeper.NewPlayer("erin", ""), false); err != nil {
+		t.Errorf("expected no player limit, got %v", err)
+	}
+	if err := room.Start(); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	if err := room.Join(minesweeper.NewPlayer("frank", ""), false); err != minesweeper.ErrGameStarted {
+		t.Errorf("expected ErrGameStarted, got %v", err)
+	}
+}
+
+func TestRoomPassword(t *testing.T) {
+	room := minesweeper.NewGameRoom("lunch", "", 4)
+	if !room.CheckPassword("") {
+		t.Errorf("expected a room without password to let anyone in")
+	}
+
+	password := "pizza"
+	settings := room.GetSettings()
+	settings.Password = &password
+	room.UpdateSettings(settings)
+
+	// the password is kept when the settings are updated without one
+	settings = room.GetSettings()
+	settings.Password = nil
+	settings.InviteOnly = true
+	room.UpdateSettings(settings)
+	if room.CheckPassword("") || room.CheckPassword("pizz") || !room.CheckPassword("pizza") {
+		t.Errorf("expected only the password to let clients in")
+	}
+
+	payload, _ := json.Marshal(room)
+	var view struct {
+		Settings map[string]interface{} `json:"settings"`
+	}
+	json.Unmarshal(payload, &view)
+	if _, ok := view.Settings["password"]; ok || view.Settings["has_password"] != true {
+		t.Errorf("expected the password to be hidden, got %s", payload)
+	}
+
+	empty := ""
+	settings.Password = &empty
+	room.UpdateSettings(settings)
+	if !room.CheckPassword("") {
+		t.Errorf("expected an empty password to remove the password")
+	}
+}
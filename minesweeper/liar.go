@@ -0,0 +1,54 @@
+package minesweeper
+
+// A liar board shows every number off by one, either above or below the true
+// count of adjacent mines. The true layout is unchanged, only the displayed
+// value of the cells lies.
+
+// assignLies picks at random whether each safe cell shows its count plus or
+// minus one.
+func (f *Field) assignLies() {
+	for _, row := range f.cells {
+		for _, cell := range row {
+			cell.lie = f.randomLie()
+		}
+	}
+}
+
+func (f *Field) randomLie() int8 {
+	if f.getRand().Intn(2) == 0 {
+		return -1
+	}
+	return 1
+}
+
+// displayedMines returns the number shown on the cell. On a liar board it is
+// the true count with the lie applied, flipped when it would fall outside of
+// 0 to 8 so that the number is always off by one.
+func (c *Cell) displayedMines() uint8 {
+	if c.lie == 0 {
+		return c.adjacentMines
+	}
+
+	val := int(c.adjacentMines) + int(c.lie)
+	if val < 0 || val > 8 {
+		val = int(c.adjacentMines) - int(c.lie)
+	}
+	return uint8(val)
+}
+
+// possibleMines returns the true counts of adjacent mines a cell may have
+// given what it shows.
+func (c *Cell) possibleMines() []int {
+	shown := int(c.displayedMines())
+	if c.lie == 0 {
+		return []int{shown}
+	}
+
+	result := []int{}
+	for _, val := range []int{shown - 1, shown + 1} {
+		if val >= 0 && val <= 8 {
+			result = append(result, val)
+		}
+	}
+	return result
+}
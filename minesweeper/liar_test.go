@@ -0,0 +1,36 @@
+package minesweeper_test
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/aryuuu/mines-party-server/minesweeper"
+)
+
+func TestLiarNumbersAreOffByOne(t *testing.T) {
+	field := minesweeper.NewFieldBuilder().
+		WithRow(16).
+		WithCol(16).
+		WithMinesCount(40).
+		WithLiar(true).
+		Build()
+
+	if _, err := field.OpenCell(8, 8, "p1"); err != nil {
+		t.Fatalf("failed to open cell: %v", err)
+	}
+
+	board := *field.GetCellString()
+	bare := *field.GetCellStringBare()
+	for i := range board {
+		for j := range board[i] {
+			shown, err := strconv.Atoi(board[i][j])
+			if err != nil {
+				continue
+			}
+			actual, _ := strconv.Atoi(bare[i][j])
+			if shown-actual != 1 && actual-shown != 1 {
+				t.Errorf("cell (%d, %d) shows %d for %d adjacent mines", i, j, shown, actual)
+			}
+		}
+	}
+}
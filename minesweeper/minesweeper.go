@@ -1,7 +1,10 @@
 package minesweeper
 
 import (
+	"math/rand"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/aryuuu/mines-party-server/utils"
 )
@@ -15,37 +18,101 @@ const (
 	DEFAULT_MINE_COUNT = 45
 )
 
+const (
+	// FIRST_CLICK_ZERO keeps the cells around the first click free of mines,
+	// so that the first click always opens an area
+	FIRST_CLICK_ZERO = "zero"
+	// FIRST_CLICK_SAFE only keeps the first clicked cell free of mines
+	FIRST_CLICK_SAFE = "safe"
+	// FIRST_CLICK_NONE lays the mines without regard for the first click
+	FIRST_CLICK_NONE = "none"
+)
+
+// IsFirstClickPolicy tells whether the policy is one of the FIRST_CLICK_
+// constants, an empty policy means FIRST_CLICK_ZERO.
+func IsFirstClickPolicy(policy string) bool {
+	switch policy {
+	case "", FIRST_CLICK_ZERO, FIRST_CLICK_SAFE, FIRST_CLICK_NONE:
+		return true
+	}
+	return false
+}
+
+// Field is a minesweeper board. All exported methods are safe for concurrent
+// use; mutations are serialized and readers are served from a cached snapshot
+// of the board that is only rebuilt after the board changes.
 type Field struct {
+	mu sync.RWMutex
+
 	row        int
 	col        int
 	minesCount int
 	// TODO: consider moving this somewhere else
-	openCells int
-	isStarted bool
-	cells     [][]*Cell
-
-	cellScore     int
-	mineScore     int
-	countColdOpen bool
+	openCells  int
+	openMines  int
+	flagsCount int
+	isStarted  bool
+	cells      [][]*Cell
+
+	questionMarks bool
+	flagBudget    bool
+	flagRule
+	scorer
+
+	// survival mode plants new mines over time, away from the cells opened
+	// last
+	survival    bool
+	recentOpens []Location
+	spawnWaves  int
+
+	// liar shows every number off by one, noGuess only lays out boards that
+	// can be solved without guessing, guessNeeded tells it failed to
+	liar        bool
+	noGuess     bool
+	guessNeeded bool
+	// firstClickPolicy decides which cells are kept free of mines around the
+	// first click, see FIRST_CLICK_ZERO
+	firstClickPolicy string
+
+	// treasures are hidden among the safe cells when treasureRatio is set
+	treasureRatio  float64
+	treasureValues []int
+	claims         []TreasureClaim
+
+	rng *rand.Rand
+
+	// the cached snapshot is only valid while snapshotVersion matches the
+	// journal version
+	journal
+	snapshotMu      sync.Mutex
+	snapshot        *[][]string
+	snapshotVersion uint64
 }
 
 type FieldBuilder struct {
-	field *Field
+	field         *Field
+	scoring       ScoringConfig
+	scoringPolicy string
 }
 
 func NewFieldBuilder() *FieldBuilder {
 	return &FieldBuilder{
 		field: &Field{
-			row:           DEFAULT_ROW,
-			col:           DEFAULT_COL,
-			minesCount:    DEFAULT_MINE_COUNT,
-			openCells:     0,
-			isStarted:     false,
-			cells:         [][]*Cell{},
-			cellScore:     DEFAULT_CELL_POINT,
-			mineScore:     DEFAULT_MINE_POINT,
-			countColdOpen: false,
+			row:        DEFAULT_ROW,
+			col:        DEFAULT_COL,
+			minesCount: DEFAULT_MINE_COUNT,
+			openCells:  0,
+			isStarted:  false,
+			cells:      [][]*Cell{},
+
+			firstClickPolicy: FIRST_CLICK_ZERO,
+		},
+		scoring: ScoringConfig{
+			CellScore:     DEFAULT_CELL_POINT,
+			MineScore:     DEFAULT_MINE_POINT,
+			CountColdOpen: false,
 		},
+		scoringPolicy: SCORING_CLASSIC,
 	}
 }
 
@@ -73,6 +140,12 @@ var difficultyMap = map[string]difficultyConfig{
 	},
 }
 
+// IsDifficulty tells whether the difficulty is a known one.
+func IsDifficulty(diff string) bool {
+	_, ok := difficultyMap[diff]
+	return ok
+}
+
 func (fb *FieldBuilder) WithDifficulty(diff string) *FieldBuilder {
 	cfg := difficultyMap["hard"]
 	if val, ok := difficultyMap[diff]; ok {
@@ -100,22 +173,89 @@ func (fb *FieldBuilder) WithMinesCount(val int) *FieldBuilder {
 }
 
 func (fb *FieldBuilder) WithCellScore(val int) *FieldBuilder {
-	fb.field.cellScore = val
+	fb.scoring.CellScore = val
 	return fb
 }
 
 func (fb *FieldBuilder) WithMineScore(val int) *FieldBuilder {
-	fb.field.mineScore = val
+	fb.scoring.MineScore = val
 	return fb
 }
 
 func (fb *FieldBuilder) WithCountColdOpen(val bool) *FieldBuilder {
-	fb.field.countColdOpen = val
+	fb.scoring.CountColdOpen = val
+	return fb
+}
+
+// WithScoringPolicy sets how the actions are scored, see SCORING_CLASSIC.
+func (fb *FieldBuilder) WithScoringPolicy(val string) *FieldBuilder {
+	fb.scoringPolicy = val
+	return fb
+}
+
+// WithFirstClickPolicy sets which cells are kept free of mines around the
+// first click, see FIRST_CLICK_ZERO.
+func (fb *FieldBuilder) WithFirstClickPolicy(val string) *FieldBuilder {
+	fb.field.firstClickPolicy = val
+	return fb
+}
+
+// WithLiar shows every number off by one, see assignLies.
+func (fb *FieldBuilder) WithLiar(val bool) *FieldBuilder {
+	fb.field.liar = val
+	return fb
+}
+
+// WithNoGuess only lays out boards that can be solved without guessing, up to
+// MAX_NO_GUESS_CELLS cells, see NeedsGuess.
+func (fb *FieldBuilder) WithNoGuess(val bool) *FieldBuilder {
+	fb.field.noGuess = val
+	return fb
+}
+
+// WithTreasures hides treasures worth one of the given values in the given
+// fraction of the safe cells, a ratio of 0 disables them.
+func (fb *FieldBuilder) WithTreasures(ratio float64, values []int) *FieldBuilder {
+	fb.field.treasureRatio = ratio
+	fb.field.treasureValues = values
+	return fb
+}
+
+// WithSurvival rewards the cells opened later in the game, see SpawnMines.
+func (fb *FieldBuilder) WithSurvival(val bool) *FieldBuilder {
+	fb.field.survival = val
+	return fb
+}
+
+func (fb *FieldBuilder) WithQuestionMarks(val bool) *FieldBuilder {
+	fb.field.questionMarks = val
+	return fb
+}
+
+// WithFlagBudget rejects new flags once there are as many flags as mines.
+func (fb *FieldBuilder) WithFlagBudget(val bool) *FieldBuilder {
+	fb.field.flagBudget = val
+	return fb
+}
+
+func (fb *FieldBuilder) WithFlagPolicy(val string) *FieldBuilder {
+	fb.field.flagPolicy = val
+	return fb
+}
+
+// WithTeamResolver sets how the teams of the players are looked up for the
+// team flag policy.
+func (fb *FieldBuilder) WithTeamResolver(teamOf func(playerID string) string) *FieldBuilder {
+	fb.field.teamOf = teamOf
 	return fb
 }
 
 func (fb *FieldBuilder) Build() *Field {
 	fb.field.cells = generateCells(fb.field.row, fb.field.col)
+	fb.field.scoring = NewScoringPolicy(fb.scoringPolicy, fb.scoring)
+	if fb.field.survival {
+		fb.field.scoring = survivalScoring{fb.field.scoring}
+	}
 	return fb.field
 }
 
@@ -127,11 +267,18 @@ func NewField(row, col, mines int) *Field {
 		isStarted:  false,
 		cells:      generateCells(row, col),
 	}
+	field.scoring = NewScoringPolicy(SCORING_CLASSIC, ScoringConfig{
+		CellScore: DEFAULT_CELL_POINT,
+		MineScore: DEFAULT_MINE_POINT,
+	})
 
 	return field
 }
 
-func (f Field) String() string {
+func (f *Field) String() string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
 	result := ""
 	for _, row := range f.cells {
 		for _, cell := range row {
@@ -142,32 +289,61 @@ func (f Field) String() string {
 	return result
 }
 
-func (f Field) IsCleared() bool {
+func (f *Field) IsCleared() bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.isCleared()
+}
+
+func (f *Field) isCleared() bool {
 	return f.openCells == f.row*f.col-f.minesCount
 }
 
-func (f Field) IsClearedForReal() bool {
+func (f *Field) IsClearedForReal() bool {
 	return f.GetOpenCellCount() == f.row*f.col-f.minesCount
 }
 
-func (f Field) GetOpenCellCount() int {
-	// TODO: actually count the open cells
-	result := 0
-	for _, row := range f.cells {
-		for _, cell := range row {
-			if cell.isOpen {
-				result++
-			}
-		}
-	}
-	return result
+func (f *Field) GetOpenCellCount() int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.openCells
 }
 
-func (f Field) GetCells() [][]*Cell {
+// NeedsGuess tells whether a no guess board could not be laid out without
+// guessing.
+func (f *Field) NeedsGuess() bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.guessNeeded
+}
+
+// GetCells returns the underlying cells. It is meant for single goroutine
+// consumers such as the TUI and must not be used while other goroutines are
+// mutating the field.
+func (f *Field) GetCells() [][]*Cell {
 	return f.cells
 }
 
-func (f Field) GetCellString() *[][]string {
+// GetCellString returns the board as seen by the players. The returned board
+// is a shared read-only snapshot, callers must not modify it.
+func (f *Field) GetCellString() *[][]string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.getCellString()
+}
+
+func (f *Field) getCellString() *[][]string {
+	f.snapshotMu.Lock()
+	defer f.snapshotMu.Unlock()
+
+	if f.snapshot != nil && f.snapshotVersion == f.version {
+		return f.snapshot
+	}
+
 	result := make([][]string, f.row)
 	for i, row := range f.cells {
 		result[i] = make([]string, f.col)
@@ -175,10 +351,16 @@ func (f Field) GetCellString() *[][]string {
 			result[i][j] = cell.GetValue()
 		}
 	}
-	return &result
+
+	f.snapshot = &result
+	f.snapshotVersion = f.version
+	return f.snapshot
 }
 
-func (f Field) GetCellStringBare() *[][]string {
+func (f *Field) GetCellStringBare() *[][]string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
 	result := make([][]string, f.row)
 	for i, row := range f.cells {
 		result[i] = make([]string, f.col)
@@ -189,63 +371,144 @@ func (f Field) GetCellStringBare() *[][]string {
 	return &result
 }
 
-func (f Field) GetRow() int {
+// GetRemainingMines returns the mines count minus the flags and the opened
+// mines, it goes negative when there are more flags than mines.
+func (f *Field) GetRemainingMines() int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.getRemainingMines()
+}
+
+func (f *Field) getRemainingMines() int {
+	return f.minesCount - f.flagsCount - f.openMines
+}
+
+func (f *Field) GetRow() int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
 	return f.row
 }
 
-func (f Field) GetCol() int {
+func (f *Field) GetCol() int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
 	return f.col
 }
 
-// OpenCell opens the cell at the given position.
+// OpenCell opens the cell at the given position and returns the points
+// earned by the action, see ScoringPolicy.
 func (f *Field) OpenCell(row, col int, playerID string) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	defer f.commit()
+
+	outcome := ActionOutcome{PlayerID: playerID, Wave: f.spawnWaves}
+	err := f.openCell(row, col, playerID, &outcome)
+	return f.score(outcome), err
+}
+
+func (f *Field) openCell(row, col int, playerID string, outcome *ActionOutcome) error {
+	if !f.isInBounds(row, col) {
+		return ErrOutOfBounds
+	}
 	cell := f.cells[row][col]
 
-	isColdOpen := f.openCells == 0
+	outcome.IsColdOpen = f.openCells == 0
 	isOpen := cell.isOpen
-	points := 0
 
 	if cell.isFlagged {
-		return points, ErrOpenFlaggedCell
+		return ErrOpenFlaggedCell
 	}
 
-	cell.Open(playerID)
+	// mines stay open when the game goes on after a mine hit
+	if isOpen && cell.isMine {
+		return ErrOpenOpenedCell
+	}
 
 	if !f.isStarted {
-		f.isStarted = true
 		genesisCoordinate := Location{
 			row: row,
 			col: col,
 		}
-		f.generateMines(genesisCoordinate)
-		f.setAdjacentMinesCount()
+		err := f.generateMines(genesisCoordinate)
+		if err != nil && err != ErrGuessNeeded {
+			return err
+		}
+		f.isStarted = true
+		f.guessNeeded = err == ErrGuessNeeded
+	}
+
+	cell.Open(playerID)
+	f.rememberOpen(row, col)
+
+	if !isOpen {
+		f.record(row, col, playerID)
 	}
 
 	if cell.isMine {
-		points += DEFAULT_MINE_POINT
-		return points, ErrOpenMine
+		f.openMines++
+		outcome.HitMine = true
+		return ErrOpenMine
 	}
 
-	if !isOpen {
-		f.openCells++
-		points++
+	if isOpen {
+		// chording compares the flags with the true number, which a liar
+		// board keeps hidden
+		if f.liar {
+			return nil
+		}
+		outcome.IsChord = true
+	} else {
+		f.tally(row, col, outcome)
+		if cell.adjacentMines == 0 {
+			outcome.IsOpening = true
+			outcome.BoardValue++
+		}
+	}
+
+	// for the same reason only the cells without adjacent mines flood on a
+	// liar board
+	if f.liar && cell.adjacentMines > 0 {
+		return nil
 	}
 
-	adjacentFlagCount := f.getAdjacentFlagCount(row, col)
-	var errQuickOpen error
-	var quickOpenPoints int
+	adjacentFlagCount := f.getAdjacentFlagCount(row, col, playerID)
 	if int(cell.adjacentMines) == adjacentFlagCount {
-		quickOpenPoints, errQuickOpen = f.QuickOpenCell(row, col, playerID)
-		points += quickOpenPoints
+		return f.quickOpenCell(row, col, playerID, outcome)
 	}
 
-	if isColdOpen && !f.countColdOpen {
-		return 0, errQuickOpen
+	return nil
+}
+
+// tally counts a newly opened safe cell in the outcome of the action. The
+// openings are counted by the callers since they span many cells.
+func (f *Field) tally(row, col int, outcome *ActionOutcome) {
+	f.openCells++
+	outcome.CellsRevealed++
+	if f.cells[row][col].adjacentMines > 0 && !f.bordersOpening(row, col) {
+		outcome.BoardValue++
 	}
-	return points, errQuickOpen
+	f.claimTreasure(row, col, outcome)
 }
 
-func (f *Field) getAdjacentFlagCount(row, col int) int {
+// bordersOpening tells whether the numbered cell at the given position is
+// uncovered along with an opening.
+func (f *Field) bordersOpening(row, col int) bool {
+	for _, loc := range f.getNeighbours(row, col) {
+		cell := f.cells[loc.row][loc.col]
+		if !cell.isMine && cell.adjacentMines == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// getAdjacentFlagCount counts the adjacent flags the player can rely on, see
+// flagRule.
+func (f *Field) getAdjacentFlagCount(row, col int, playerID string) int {
 	result := 0
 
 	for i := row - 1; i <= row+1; i++ {
@@ -258,7 +521,7 @@ func (f *Field) getAdjacentFlagCount(row, col int) int {
 				continue
 			}
 
-			if f.cells[i][j].isFlagged {
+			if f.countsAsFlag(f.cells[i][j], playerID) {
 				result++
 			}
 		}
@@ -267,16 +530,43 @@ func (f *Field) getAdjacentFlagCount(row, col int) int {
 	return result
 }
 
-// ToggleFlagCell flags the cell at the given position.
-// TODO: maybe consider doing the flag x mines count check?
+// ToggleFlagCell cycles the mark of the cell at the given position, see
+// Cell.Mark. Question marks are only used when enabled on the field. With the
+// flag budget enabled no new flag is placed once there are as many flags as
+// mines.
 func (f *Field) ToggleFlagCell(row, col int, playerID string) (*Cell, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	defer f.commit()
+
+	if !f.isInBounds(row, col) {
+		return nil, ErrOutOfBounds
+	}
 	cell := f.cells[row][col]
 
 	if cell.isOpen {
 		return nil, ErrFlagOpenedCell
 	}
 
-	cell.Flag(playerID)
+	if !f.canMark(cell, playerID) {
+		return nil, ErrFlagNotOwned
+	}
+
+	isPlacingFlag := !cell.isFlagged && !cell.isQuestioned
+	if isPlacingFlag && f.flagBudget && f.flagsCount >= f.minesCount {
+		return nil, ErrFlagBudgetExceeded
+	}
+
+	wasFlagged := cell.isFlagged
+	cell.Mark(playerID, f.questionMarks)
+	if wasFlagged != cell.isFlagged {
+		if cell.isFlagged {
+			f.flagsCount++
+		} else {
+			f.flagsCount--
+		}
+	}
+	f.record(row, col, playerID)
 
 	return cell, nil
 }
@@ -284,105 +574,180 @@ func (f *Field) ToggleFlagCell(row, col int, playerID string) (*Cell, error) {
 // QuickOpenCell opens the cell at the given position and all the adjacent cells if the number of adjacent flagged cells is equal to the number of adjacent mines.
 // TODO: finish function, see if we need to return more than just error (maybe all the newly open cell?)
 func (f *Field) QuickOpenCell(row, col int, playerID string) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	defer f.commit()
+
+	// see openCell
+	if f.liar {
+		return 0, nil
+	}
+
+	outcome := ActionOutcome{PlayerID: playerID, IsChord: true, Wave: f.spawnWaves}
+	err := f.quickOpenCell(row, col, playerID, &outcome)
+	return f.score(outcome), err
+}
+
+func (f *Field) quickOpenCell(row, col int, playerID string, outcome *ActionOutcome) error {
 	// flood fill rule:
 	// if current cell is not a mine and has no adjacent mines, open all adjacent cells
 	// if current cell is not a mine and has adjacent mines, open only the current cell
 	// if current cell is a mine, do nothing
 	// if current cell is flagged, do nothing
 	// if current cell is open, open all adjacent cells that are not flagged
+	//
+	// cells are opened as soon as they are queued, so every cell is visited at
+	// most once and the queue never holds more than one entry per cell.
+
+	// the neighbours of a cell without adjacent mines belong to its opening
+	inOpening := f.cells[row][col].adjacentMines == 0
+	queue := []Location{}
+	open := func(loc Location) {
+		cell := f.cells[loc.row][loc.col]
+		cell.Open(playerID)
+		f.record(loc.row, loc.col, playerID)
+		f.tally(loc.row, loc.col, outcome)
 
-	locationsToOpen := []Location{}
-	for i := row - 1; i <= row+1; i++ {
-		for j := col - 1; j <= col+1; j++ {
-			if i == row && j == col {
-				continue
-			}
-
-			locationsToOpen = append(locationsToOpen, Location{
-				row: i,
-				col: j,
-			})
+		// TODO: also open when adjacentFlagCount == adjacentMinesCount
+		if cell.adjacentMines == 0 {
+			queue = append(queue, loc)
 		}
 	}
 
-	points := 0
-	for len(locationsToOpen) > 0 {
-		loc := locationsToOpen[0]
-		locationsToOpen = locationsToOpen[1:]
-
-		if loc.row < 0 || loc.row >= f.row || loc.col < 0 || loc.col >= f.col {
-			continue
-		}
-
+	head := 0
+	for _, loc := range f.getNeighbours(row, col) {
 		cell := f.cells[loc.row][loc.col]
 
-		if cell.isMine && !cell.isFlagged {
-			points = DEFAULT_MINE_POINT
-			return points, ErrOpenMine
-		}
-
 		if cell.isFlagged || cell.isOpen {
 			continue
 		}
 
-		cell.Open(playerID)
-		f.openCells++
-		points += DEFAULT_CELL_POINT
+		if cell.isMine {
+			cell.Open(playerID)
+			f.record(loc.row, loc.col, playerID)
+			f.openMines++
+			outcome.HitMine = true
+			return ErrOpenMine
+		}
 
-		// TODO: also open when adjacentFlagCount == adjacentMinesCount
-		if cell.adjacentMines == 0 {
-			for i := loc.row - 1; i <= loc.row+1; i++ {
-				for j := loc.col - 1; j <= loc.col+1; j++ {
-					if i == loc.row && j == loc.col {
-						continue
-					}
-
-					locationsToOpen = append(locationsToOpen, Location{
-						row: i,
-						col: j,
-					})
+		if !inOpening && cell.adjacentMines == 0 {
+			outcome.BoardValue++
+		}
+		open(loc)
+
+		// flood each opening before moving to the next neighbour, so that an
+		// opening reached from two neighbours is only counted once. The
+		// neighbours of a cell without adjacent mines are never mines.
+		for ; head < len(queue); head++ {
+			loc := queue[head]
+			for _, next := range f.getNeighbours(loc.row, loc.col) {
+				cell := f.cells[next.row][next.col]
+				if cell.isFlagged || cell.isOpen {
+					continue
 				}
+
+				open(next)
 			}
 		}
 	}
 
-	return points, nil
+	return nil
+}
+
+// getNeighbours returns the in bounds locations around the given position.
+func (f *Field) getNeighbours(row, col int) []Location {
+	neighbours := getNeighbours(row, col)
+	result := neighbours[:0]
+	for _, loc := range neighbours {
+		if f.isInBounds(loc.row, loc.col) {
+			result = append(result, loc)
+		}
+	}
+	return result
 }
 
 // generateCells generates row * col cells.
 func generateCells(row, col int) [][]*Cell {
+	// allocate all the cells at once, large boards would otherwise need one
+	// allocation per cell
+	backing := make([]Cell, row*col)
 	cells := make([][]*Cell, row)
 	for i := range cells {
 		cells[i] = make([]*Cell, col)
 		for j := range cells[i] {
-			cells[i][j] = &Cell{}
+			cells[i][j] = &backing[i*col+j]
 		}
 	}
 	return cells
 }
 
-// generateMines generates mines randomly.
+// generateMines generates mines randomly, along with the numbers of the
+// cells. A no guess board is laid out again until it can be solved without
+// guessing, it settles for the last layout and returns ErrGuessNeeded after
+// MAX_NO_GUESS_ATTEMPTS, or right away above MAX_NO_GUESS_CELLS.
 func (f *Field) generateMines(genesisCoordinate Location) error {
-	minesLocations, err := f.generateMinesLocations(genesisCoordinate, f.minesCount)
-	if err != nil {
-		return err
+	attempts := 1
+	checked := f.noGuess && f.row*f.col <= MAX_NO_GUESS_CELLS
+	if checked {
+		attempts = MAX_NO_GUESS_ATTEMPTS
 	}
+	solved := !f.noGuess
 
-	for _, loc := range minesLocations {
-		f.cells[loc.row][loc.col].isMine = true
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			f.clearMines()
+		}
+
+		minesLocations, err := f.generateMinesLocations(genesisCoordinate, f.minesCount)
+		if err != nil {
+			return err
+		}
+
+		for _, loc := range minesLocations {
+			f.cells[loc.row][loc.col].isMine = true
+		}
+		f.setAdjacentMinesCount()
+		if f.liar {
+			f.assignLies()
+		}
+
+		if checked && f.isSolvable(genesisCoordinate) {
+			solved = true
+			break
+		}
 	}
 
+	if f.treasureRatio > 0 {
+		f.generateTreasures(f.safeCellsAwayFrom(genesisCoordinate))
+	}
+
+	if !solved {
+		return ErrGuessNeeded
+	}
 	return nil
 }
 
+// clearMines undoes a layout of generateMines.
+func (f *Field) clearMines() {
+	for _, row := range f.cells {
+		for _, cell := range row {
+			cell.isMine = false
+			cell.adjacentMines = 0
+			cell.lie = 0
+		}
+	}
+}
+
 func (f *Field) setAdjacentMinesCount() {
 	for i, row := range f.cells {
 		for j, cell := range row {
-			if cell.isMine {
+			if !cell.isMine {
 				continue
 			}
 
-			cell.adjacentMines = uint8(f.getAdjacentMinesCount(i, j))
+			for _, loc := range f.getNeighbours(i, j) {
+				f.cells[loc.row][loc.col].adjacentMines++
+			}
 		}
 	}
 }
@@ -409,48 +774,82 @@ func (f *Field) getAdjacentMinesCount(row, col int) int {
 	return result
 }
 
+func (f *Field) isInBounds(row, col int) bool {
+	return row >= 0 && row < f.row && col >= 0 && col < f.col
+}
+
 // generateMinesLocations generates mines locations randomly.
-func (f Field) generateMinesLocations(genesisCoordinate Location, mines int) ([]Location, error) {
+// The candidates are shuffled just enough to pick the mines, which keeps the
+// generation linear in the board size regardless of the mines density.
+func (f *Field) generateMinesLocations(genesisCoordinate Location, mines int) ([]Location, error) {
 	cellCount := f.row * f.col
 	if mines > cellCount {
 		return nil, ErrTooManyMines
 	}
 
-	cellHasMine := make(map[int]bool)
-	for i := genesisCoordinate.row - 1; i <= genesisCoordinate.row+1; i++ {
-		for j := genesisCoordinate.col - 1; j <= genesisCoordinate.col+1; j++ {
-			cellHasMine[i*f.col+j] = true
+	candidates := make([]int32, 0, cellCount)
+	for i := 0; i < f.row; i++ {
+		for j := 0; j < f.col; j++ {
+			if isFirstClickProtected(f.firstClickPolicy, i, j, genesisCoordinate) {
+				continue
+			}
+			candidates = append(candidates, int32(i*f.col+j))
 		}
 	}
 
+	if mines > len(candidates) {
+		return nil, ErrTooManyMines
+	}
+
+	rng := f.getRand()
 	minesLocations := make([]Location, mines)
 	for i := 0; i < mines; i++ {
-		randomLoc := 0
-		for {
-			randomLoc = utils.GenerateRandomInt(0, cellCount)
-			if !cellHasMine[randomLoc] {
-				break
-			}
-		}
-
-		cellHasMine[randomLoc] = true
+		pick := i + rng.Intn(len(candidates)-i)
+		candidates[i], candidates[pick] = candidates[pick], candidates[i]
 
 		minesLocations[i] = Location{
-			row: randomLoc / f.col,
-			col: randomLoc % f.col,
+			row: int(candidates[i]) / f.col,
+			col: int(candidates[i]) % f.col,
 		}
 	}
 
 	return minesLocations, nil
 }
 
+// isFirstClickProtected tells whether the cell at the given position is kept
+// free of mines by the first click policy. Unknown policies fall back to
+// FIRST_CLICK_ZERO. Only in bounds positions are ever checked, so the area
+// around a click on an edge or a corner is simply smaller.
+func isFirstClickProtected(policy string, row, col int, firstClick Location) bool {
+	switch policy {
+	case FIRST_CLICK_NONE:
+		return false
+	case FIRST_CLICK_SAFE:
+		return row == firstClick.row && col == firstClick.col
+	}
+	return utils.Abs(row-firstClick.row) <= 1 && utils.Abs(col-firstClick.col) <= 1
+}
+
+func (f *Field) getRand() *rand.Rand {
+	if f.rng == nil {
+		f.rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	return f.rng
+}
+
 type Cell struct {
 	isMine        bool
 	isOpen        bool
 	isFlagged     bool
+	isQuestioned  bool
 	adjacentMines uint8
-	openerID      string
-	flaggerID     string
+	// lie is added to the number shown on a liar board, see displayedMines
+	lie int8
+	// treasure is the value of the treasure hidden in the cell, if any
+	treasure     int
+	openerID     string
+	flaggerID    string
+	questionerID string
 }
 
 func (c Cell) GetValueBare() string {
@@ -458,6 +857,10 @@ func (c Cell) GetValueBare() string {
 		return "X"
 	}
 
+	if c.treasure > 0 {
+		return treasureValue(c.adjacentMines)
+	}
+
 	result := strconv.Itoa(int(c.adjacentMines))
 
 	return result
@@ -468,13 +871,20 @@ func (c Cell) GetValue() string {
 		if c.isMine {
 			return "X"
 		}
-		return strconv.Itoa(int(c.adjacentMines))
+		if c.treasure > 0 {
+			return treasureValue(c.displayedMines())
+		}
+		return strconv.Itoa(int(c.displayedMines()))
 	}
 
 	if c.isFlagged {
 		return "F"
 	}
 
+	if c.isQuestioned {
+		return "?"
+	}
+
 	return " "
 }
 
@@ -486,17 +896,51 @@ func (c *Cell) IsMine() bool {
 	return c.isMine
 }
 
+func (c *Cell) IsFlagged() bool {
+	return c.isFlagged
+}
+
+func (c *Cell) IsQuestioned() bool {
+	return c.isQuestioned
+}
+
+func (c *Cell) GetFlaggerID() string {
+	return c.flaggerID
+}
+
+func (c *Cell) GetQuestionerID() string {
+	return c.questionerID
+}
+
 func (c *Cell) Open(openerID string) {
 	c.isOpen = true
+	c.isQuestioned = false
 	c.openerID = openerID
 }
 
-// TODO: maybe consider doing the flag x mines count check?
 func (c *Cell) Flag(playerID string) {
 	c.isFlagged = !c.isFlagged
+	c.isQuestioned = false
 	c.flaggerID = playerID
 }
 
+// Mark cycles the mark of the cell through flag, question mark (when enabled)
+// and clear.
+func (c *Cell) Mark(playerID string, withQuestionMark bool) {
+	switch {
+	case c.isFlagged && withQuestionMark:
+		c.isFlagged = false
+		c.isQuestioned = true
+		c.questionerID = playerID
+	case c.isFlagged || c.isQuestioned:
+		c.isFlagged = false
+		c.isQuestioned = false
+	default:
+		c.isFlagged = true
+		c.flaggerID = playerID
+	}
+}
+
 type Location struct {
 	row int
 	col int
@@ -0,0 +1,247 @@
+package minesweeper_test
+
+import (
+	"testing"
+
+	"github.com/aryuuu/mines-party-server/minesweeper"
+)
+
+func TestFieldDiffSince(t *testing.T) {
+	field := minesweeper.NewField(8, 8, 10)
+
+	if _, err := field.OpenCell(0, 0, "p1"); err != nil {
+		t.Fatalf("failed to open the first cell: %v", err)
+	}
+	board, version := field.GetSnapshot()
+	if version != 1 {
+		t.Fatalf("expected version 1 after the first action, got %d", version)
+	}
+
+	diff, ok := field.DiffSince(0)
+	if !ok {
+		t.Fatalf("expected a diff from the initial version")
+	}
+	if diff.Version != version {
+		t.Errorf("expected diff version %d, got %d", version, diff.Version)
+	}
+	for _, change := range diff.Changes {
+		if (*board)[change.Row][change.Col] != change.Value {
+			t.Errorf("cell (%d, %d) is %q on the board but %q in the diff", change.Row, change.Col, (*board)[change.Row][change.Col], change.Value)
+		}
+		if change.ActorID != "p1" {
+			t.Errorf("expected actor p1, got %q", change.ActorID)
+		}
+	}
+
+	if _, ok := field.DiffSince(version + 1); ok {
+		t.Errorf("expected no diff for a version from the future")
+	}
+
+	diff, ok = field.DiffSince(version)
+	if !ok || len(diff.Changes) != 0 {
+		t.Errorf("expected an empty diff for an up to date client")
+	}
+}
+
+func TestFloodFillClearsEmptyLargeField(t *testing.T) {
+	field := minesweeper.NewFieldBuilder().
+		WithRow(1000).
+		WithCol(1000).
+		WithMinesCount(0).
+		Build()
+
+	if _, err := field.OpenCell(500, 500, "p1"); err != nil {
+		t.Fatalf("failed to open cell: %v", err)
+	}
+	if !field.IsCleared() {
+		t.Errorf("expected the field to be cleared, %d cells open", field.GetOpenCellCount())
+	}
+}
+
+func TestGenerateMinesAtFullDensity(t *testing.T) {
+	// every cell but the 3x3 around the first click is a mine
+	field := minesweeper.NewFieldBuilder().
+		WithRow(200).
+		WithCol(200).
+		WithMinesCount(200*200 - 9).
+		Build()
+
+	if _, err := field.OpenCell(100, 100, "p1"); err != nil {
+		t.Fatalf("failed to open cell: %v", err)
+	}
+	if !field.IsCleared() {
+		t.Errorf("expected the field to be cleared, %d cells open", field.GetOpenCellCount())
+	}
+}
+
+func BenchmarkBuildLargeField(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		minesweeper.NewFieldBuilder().
+			WithRow(1000).
+			WithCol(1000).
+			Build()
+	}
+}
+
+func BenchmarkFirstOpenDenseLargeField(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		field := minesweeper.NewFieldBuilder().
+			WithRow(1000).
+			WithCol(1000).
+			WithMinesCount(800000).
+			Build()
+		b.StartTimer()
+
+		field.OpenCell(500, 500, "p1")
+	}
+}
+
+func BenchmarkFloodFillLargeField(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		field := minesweeper.NewFieldBuilder().
+			WithRow(1000).
+			WithCol(1000).
+			WithMinesCount(1000).
+			Build()
+		b.StartTimer()
+
+		field.OpenCell(500, 500, "p1")
+	}
+}
+
+func BenchmarkIsClearedLargeField(b *testing.B) {
+	field := minesweeper.NewFieldBuilder().
+		WithRow(1000).
+		WithCol(1000).
+		WithMinesCount(150000).
+		Build()
+	field.OpenCell(500, 500, "p1")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		field.IsCleared()
+	}
+}
+
+func TestToggleFlagCellCyclesQuestionMark(t *testing.T) {
+	field := minesweeper.NewFieldBuilder().
+		WithRow(5).
+		WithCol(5).
+		WithMinesCount(3).
+		WithQuestionMarks(true).
+		Build()
+
+	for _, expected := range []string{"F", "?", " ", "F"} {
+		cell, err := field.ToggleFlagCell(0, 0, "p1")
+		if err != nil {
+			t.Fatalf("failed to toggle the mark: %v", err)
+		}
+		if cell.GetValue() != expected {
+			t.Errorf("expected %q, got %q", expected, cell.GetValue())
+		}
+	}
+}
+
+func TestFlagPolicy(t *testing.T) {
+	teams := map[string]string{"p1": "red", "p2": "red", "p3": "blue"}
+	teamOf := func(playerID string) string { return teams[playerID] }
+
+	testCases := []struct {
+		policy  string
+		remover string
+		wantErr error
+	}{
+		{minesweeper.FLAG_POLICY_ANYONE, "p3", nil},
+		{minesweeper.FLAG_POLICY_OWNER, "p1", nil},
+		{minesweeper.FLAG_POLICY_OWNER, "p2", minesweeper.ErrFlagNotOwned},
+		{minesweeper.FLAG_POLICY_TEAM, "p2", nil},
+		{minesweeper.FLAG_POLICY_TEAM, "p3", minesweeper.ErrFlagNotOwned},
+	}
+
+	for _, tc := range testCases {
+		field := minesweeper.NewFieldBuilder().
+			WithRow(5).
+			WithCol(5).
+			WithMinesCount(3).
+			WithFlagPolicy(tc.policy).
+			WithTeamResolver(teamOf).
+			Build()
+
+		if _, err := field.ToggleFlagCell(0, 0, "p1"); err != nil {
+			t.Fatalf("failed to place the flag: %v", err)
+		}
+		if _, err := field.ToggleFlagCell(0, 0, tc.remover); err != tc.wantErr {
+			t.Errorf("policy %s, remover %s: expected %v, got %v", tc.policy, tc.remover, tc.wantErr, err)
+		}
+	}
+}
+
+func TestFlagBudget(t *testing.T) {
+	field := minesweeper.NewFieldBuilder().
+		WithRow(5).
+		WithCol(5).
+		WithMinesCount(2).
+		WithFlagBudget(true).
+		Build()
+
+	for col := 0; col < 2; col++ {
+		if _, err := field.ToggleFlagCell(0, col, "p1"); err != nil {
+			t.Fatalf("failed to place flag %d: %v", col, err)
+		}
+	}
+	if remaining := field.GetRemainingMines(); remaining != 0 {
+		t.Errorf("expected no remaining mines, got %d", remaining)
+	}
+
+	if _, err := field.ToggleFlagCell(0, 2, "p1"); err != minesweeper.ErrFlagBudgetExceeded {
+		t.Errorf("expected ErrFlagBudgetExceeded, got %v", err)
+	}
+
+	// removing a flag frees the budget
+	if _, err := field.ToggleFlagCell(0, 0, "p1"); err != nil {
+		t.Fatalf("failed to remove flag: %v", err)
+	}
+	if _, err := field.ToggleFlagCell(0, 2, "p1"); err != nil {
+		t.Errorf("expected the flag to be placed, got %v", err)
+	}
+}
+
+func TestFirstClickPolicy(t *testing.T) {
+	// the boards hold as many mines as the policy allows, so that every
+	// unprotected cell is a mine
+	testCases := []struct {
+		name      string
+		policy    string
+		row, col  int
+		mines     int
+		wantValue string
+		wantErr   error
+	}{
+		{"zero in a corner", minesweeper.FIRST_CLICK_ZERO, 0, 0, 25 - 4, "0", nil},
+		{"zero at the opposite corner", minesweeper.FIRST_CLICK_ZERO, 4, 4, 25 - 4, "0", nil},
+		{"zero on an edge", minesweeper.FIRST_CLICK_ZERO, 0, 2, 25 - 6, "0", nil},
+		{"zero in the middle", minesweeper.FIRST_CLICK_ZERO, 2, 2, 25 - 9, "0", nil},
+		{"safe in a corner", minesweeper.FIRST_CLICK_SAFE, 0, 4, 24, "3", nil},
+		{"safe on an edge", minesweeper.FIRST_CLICK_SAFE, 4, 2, 24, "5", nil},
+		{"safe in the middle", minesweeper.FIRST_CLICK_SAFE, 2, 2, 24, "8", nil},
+		{"none in a corner", minesweeper.FIRST_CLICK_NONE, 4, 0, 25, "X", minesweeper.ErrOpenMine},
+	}
+
+	for _, tc := range testCases {
+		field := minesweeper.NewFieldBuilder().
+			WithRow(5).
+			WithCol(5).
+			WithMinesCount(tc.mines).
+			WithFirstClickPolicy(tc.policy).
+			Build()
+
+		if _, err := field.OpenCell(tc.row, tc.col, "p1"); err != tc.wantErr {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.wantErr, err)
+		}
+		if got := (*field.GetCellString())[tc.row][tc.col]; got != tc.wantValue {
+			t.Errorf("%s: expected %q, got %q", tc.name, tc.wantValue, got)
+		}
+	}
+}
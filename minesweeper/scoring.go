@@ -0,0 +1,190 @@
+package minesweeper
+
+import "time"
+
+const (
+	// SCORING_CLASSIC awards the cell score per opened cell
+	SCORING_CLASSIC = "classic"
+	// SCORING_STREAK multiplies the classic points of a player on a streak of
+	// safe actions, hitting a mine breaks the streak
+	SCORING_STREAK = "streak"
+	// SCORING_SPEED adds a bonus to the classic points of the actions quickly
+	// following the previous one of the same player
+	SCORING_SPEED = "speed"
+	// SCORING_3BV awards points per 3BV solved, the minimal number of clicks
+	// needed to clear the board, rather than per opened cell
+	SCORING_3BV = "3bv"
+
+	// DEFAULT_STREAK_STEP is the number of safe actions in a row needed to
+	// raise the streak multiplier by one
+	DEFAULT_STREAK_STEP           = 5
+	DEFAULT_MAX_STREAK_MULTIPLIER = 4
+	// DEFAULT_SPEED_WINDOW is how soon an action must follow the previous one
+	// to earn a bonus, the bonus goes from doubling the points down to nothing
+	// over the window
+	DEFAULT_SPEED_WINDOW = 3 * time.Second
+	// DEFAULT_3BV_POINT is the number of cell scores a 3BV is worth
+	DEFAULT_3BV_POINT = 10
+)
+
+// IsScoringPolicy tells whether the policy is one of the SCORING_ constants,
+// an empty policy means SCORING_CLASSIC.
+func IsScoringPolicy(policy string) bool {
+	switch policy {
+	case "", SCORING_CLASSIC, SCORING_STREAK, SCORING_SPEED, SCORING_3BV:
+		return true
+	}
+	return false
+}
+
+// ActionOutcome describes what a single open action did to the board.
+type ActionOutcome struct {
+	PlayerID string
+	// CellsRevealed is the number of safe cells opened by the action
+	CellsRevealed int
+	// BoardValue is the 3BV solved by the action: one per opening uncovered
+	// and one per numbered cell outside of any opening
+	BoardValue int
+	// RingsPushed is how many rings the action pushed the endless board out
+	RingsPushed int
+	// Wave is the number of mine waves spawned so far in survival mode
+	Wave int
+	// TreasurePoints is the value of the treasures opened by the action, it
+	// is added on top of the points of the scoring policy
+	TreasurePoints int
+	// HitMine is set when the action opened a mine
+	HitMine bool
+	// IsChord is set when the action was on an already opened cell
+	IsChord bool
+	// IsOpening is set when the opened cell has no adjacent mines
+	IsOpening  bool
+	IsColdOpen bool
+	// SinceLastAction is the time since the previous scored action of the
+	// player, zero on the first one
+	SinceLastAction time.Duration
+}
+
+// ScoringPolicy turns the outcome of an action into points. The boards
+// serialize their actions, so the policies may keep state without locking.
+type ScoringPolicy interface {
+	Score(outcome ActionOutcome) int
+}
+
+// ScoringConfig holds the base points shared by the scoring policies.
+type ScoringConfig struct {
+	CellScore     int
+	MineScore     int
+	RingScore     int
+	CountColdOpen bool
+}
+
+// NewScoringPolicy returns the policy with the given name, falling back to
+// SCORING_CLASSIC for anything unknown.
+func NewScoringPolicy(name string, config ScoringConfig) ScoringPolicy {
+	switch name {
+	case SCORING_STREAK:
+		return &streakScoring{
+			base:    classicScoring{config},
+			streaks: map[string]int{},
+		}
+	case SCORING_SPEED:
+		return speedScoring{classicScoring{config}}
+	case SCORING_3BV:
+		return boardValueScoring{config}
+	}
+	return classicScoring{config}
+}
+
+type classicScoring struct {
+	ScoringConfig
+}
+
+func (s classicScoring) Score(outcome ActionOutcome) int {
+	if outcome.HitMine {
+		return s.MineScore
+	}
+	if outcome.IsColdOpen && !s.CountColdOpen {
+		return 0
+	}
+	return outcome.CellsRevealed*s.CellScore + outcome.RingsPushed*s.RingScore
+}
+
+type streakScoring struct {
+	base    classicScoring
+	streaks map[string]int
+}
+
+func (s *streakScoring) Score(outcome ActionOutcome) int {
+	points := s.base.Score(outcome)
+	if outcome.HitMine {
+		delete(s.streaks, outcome.PlayerID)
+		return points
+	}
+	if points <= 0 {
+		return points
+	}
+
+	s.streaks[outcome.PlayerID]++
+	multiplier := 1 + (s.streaks[outcome.PlayerID]-1)/DEFAULT_STREAK_STEP
+	if multiplier > DEFAULT_MAX_STREAK_MULTIPLIER {
+		multiplier = DEFAULT_MAX_STREAK_MULTIPLIER
+	}
+	return points * multiplier
+}
+
+type speedScoring struct {
+	base classicScoring
+}
+
+func (s speedScoring) Score(outcome ActionOutcome) int {
+	points := s.base.Score(outcome)
+	since := outcome.SinceLastAction
+	if outcome.HitMine || points <= 0 || since <= 0 || since >= DEFAULT_SPEED_WINDOW {
+		return points
+	}
+
+	return points + int(int64(points)*int64(DEFAULT_SPEED_WINDOW-since)/int64(DEFAULT_SPEED_WINDOW))
+}
+
+type boardValueScoring struct {
+	ScoringConfig
+}
+
+func (s boardValueScoring) Score(outcome ActionOutcome) int {
+	if outcome.HitMine {
+		return s.MineScore
+	}
+	if outcome.IsColdOpen && !s.CountColdOpen {
+		return 0
+	}
+	return outcome.BoardValue*DEFAULT_3BV_POINT*s.CellScore + outcome.RingsPushed*s.RingScore
+}
+
+// scorer scores the actions on a board, it relies on the locking of the board
+// embedding it.
+type scorer struct {
+	scoring    ScoringPolicy
+	lastAction map[string]time.Time
+}
+
+// score fills in the timing of the action and scores it. Actions that did not
+// change the board are worth nothing and are not timed.
+func (s *scorer) score(outcome ActionOutcome) int {
+	if outcome.CellsRevealed == 0 && !outcome.HitMine {
+		return 0
+	}
+
+	now := time.Now()
+	if last, ok := s.lastAction[outcome.PlayerID]; ok {
+		outcome.SinceLastAction = now.Sub(last)
+	}
+	if s.lastAction == nil {
+		s.lastAction = map[string]time.Time{}
+	}
+	s.lastAction[outcome.PlayerID] = now
+
+	if s.scoring == nil {
+		return outcome.TreasurePoints
+	}
+	return s.scoring.Score(outcome) + outcome.TreasurePoints
+}
@@ -0,0 +1,72 @@
+package minesweeper_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aryuuu/mines-party-server/minesweeper"
+)
+
+func TestScoringPolicies(t *testing.T) {
+	config := minesweeper.ScoringConfig{
+		CellScore: minesweeper.DEFAULT_CELL_POINT,
+		MineScore: minesweeper.DEFAULT_MINE_POINT,
+	}
+	safe := minesweeper.ActionOutcome{
+		PlayerID:        "p1",
+		CellsRevealed:   4,
+		BoardValue:      2,
+		SinceLastAction: time.Minute,
+	}
+	mine := minesweeper.ActionOutcome{PlayerID: "p1", HitMine: true}
+
+	testCases := []struct {
+		policy   string
+		outcomes []minesweeper.ActionOutcome
+		want     []int
+	}{
+		{minesweeper.SCORING_CLASSIC, []minesweeper.ActionOutcome{safe, mine}, []int{4, -50}},
+		{minesweeper.SCORING_3BV, []minesweeper.ActionOutcome{safe}, []int{20}},
+		{
+			minesweeper.SCORING_STREAK,
+			[]minesweeper.ActionOutcome{safe, safe, safe, safe, safe, safe, mine, safe},
+			[]int{4, 4, 4, 4, 4, 8, -50, 4},
+		},
+		{
+			minesweeper.SCORING_SPEED,
+			[]minesweeper.ActionOutcome{
+				safe,
+				{PlayerID: "p1", CellsRevealed: 4, SinceLastAction: time.Nanosecond},
+			},
+			[]int{4, 7},
+		},
+	}
+
+	for _, tc := range testCases {
+		policy := minesweeper.NewScoringPolicy(tc.policy, config)
+		for i, outcome := range tc.outcomes {
+			if got := policy.Score(outcome); got != tc.want[i] {
+				t.Errorf("policy %s, action %d: expected %d points, got %d", tc.policy, i, tc.want[i], got)
+			}
+		}
+	}
+}
+
+func TestFieldBoardValueScoring(t *testing.T) {
+	// without mines the whole board is a single opening, worth a single 3BV
+	field := minesweeper.NewFieldBuilder().
+		WithRow(10).
+		WithCol(10).
+		WithMinesCount(0).
+		WithCountColdOpen(true).
+		WithScoringPolicy(minesweeper.SCORING_3BV).
+		Build()
+
+	points, err := field.OpenCell(5, 5, "p1")
+	if err != nil {
+		t.Fatalf("failed to open cell: %v", err)
+	}
+	if want := minesweeper.DEFAULT_3BV_POINT * minesweeper.DEFAULT_CELL_POINT; points != want {
+		t.Errorf("expected %d points, got %d", want, points)
+	}
+}
@@ -0,0 +1,321 @@
+package minesweeper
+
+const (
+	// MAX_SOLVER_COMPONENT caps the number of unknown cells the solver
+	// enumerates at once, larger groups are left alone
+	MAX_SOLVER_COMPONENT = 16
+	// MAX_NO_GUESS_ATTEMPTS is the number of layouts tried for a no guess
+	// board before settling for the last one
+	MAX_NO_GUESS_ATTEMPTS = 200
+	// MAX_NO_GUESS_CELLS is the largest board laid out without guesses,
+	// larger boards are laid out at random and need guessing, see NeedsGuess
+	MAX_NO_GUESS_CELLS = 50 * 50
+)
+
+// solver plays the board the way a player would, deducing mines and safe
+// cells from the numbers shown only. It opens cells like the field does,
+// flooding the cells without adjacent mines. It does not use the total mines
+// count, so a board it solves can always be solved without guessing.
+type solver struct {
+	f *Field
+	// revealed and mine are indexed by row*col+col, mine holds the mines
+	// deduced so far
+	revealed []bool
+	mine     []bool
+	safeLeft int
+}
+
+// solverConstraint states that the mines among cells add up to one of
+// counts.
+type solverConstraint struct {
+	cells  []int
+	counts []int
+}
+
+// isSolvable tells whether the board can be cleared without guessing from a
+// first open at the given location.
+func (f *Field) isSolvable(start Location) bool {
+	if f.cells[start.row][start.col].isMine {
+		return false
+	}
+
+	s := &solver{
+		f:        f,
+		revealed: make([]bool, f.row*f.col),
+		mine:     make([]bool, f.row*f.col),
+		safeLeft: f.row*f.col - f.minesCount,
+	}
+
+	s.reveal(start.row*f.col + start.col)
+	for s.safeLeft > 0 {
+		if !s.step() {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *solver) cellAt(idx int) *Cell {
+	return s.f.cells[idx/s.f.col][idx%s.f.col]
+}
+
+func (s *solver) neighbours(idx int) []int {
+	locs := s.f.getNeighbours(idx/s.f.col, idx%s.f.col)
+	result := make([]int, len(locs))
+	for i, loc := range locs {
+		result[i] = loc.row*s.f.col + loc.col
+	}
+	return result
+}
+
+// reveal opens a safe cell, flooding the cells without adjacent mines.
+func (s *solver) reveal(idx int) {
+	stack := []int{idx}
+	for len(stack) > 0 {
+		idx := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if s.revealed[idx] {
+			continue
+		}
+
+		s.revealed[idx] = true
+		s.safeLeft--
+		if s.cellAt(idx).adjacentMines == 0 {
+			stack = append(stack, s.neighbours(idx)...)
+		}
+	}
+}
+
+// step deduces what it can from the opened cells and returns whether it made
+// any progress.
+func (s *solver) step() bool {
+	constraints := s.constraints()
+
+	// cells sharing a constraint are solved together
+	parent := map[int]int{}
+	var find func(idx int) int
+	find = func(idx int) int {
+		if parent[idx] != idx {
+			parent[idx] = find(parent[idx])
+		}
+		return parent[idx]
+	}
+	for _, c := range constraints {
+		for _, idx := range c.cells {
+			if _, ok := parent[idx]; !ok {
+				parent[idx] = idx
+			}
+		}
+		for _, idx := range c.cells[1:] {
+			parent[find(idx)] = find(c.cells[0])
+		}
+	}
+
+	components := map[int][]solverConstraint{}
+	for _, c := range constraints {
+		root := find(c.cells[0])
+		components[root] = append(components[root], c)
+	}
+
+	progress := false
+	for _, group := range components {
+		for _, sub := range splitComponent(group) {
+			safe, mines := s.solveComponent(sub)
+			for _, idx := range mines {
+				if !s.mine[idx] {
+					s.mine[idx] = true
+					progress = true
+				}
+			}
+			for _, idx := range safe {
+				if !s.revealed[idx] {
+					s.reveal(idx)
+					progress = true
+				}
+			}
+		}
+	}
+	return progress
+}
+
+// splitComponent returns the group as is when it is small enough to be
+// enumerated, or else one smaller group per constraint made of the
+// constraints overlapping it. Leaving constraints out only allows more
+// layouts, so what holds for a smaller group holds for the whole.
+func splitComponent(group []solverConstraint) [][]solverConstraint {
+	cells := map[int][]int{}
+	for i, c := range group {
+		for _, idx := range c.cells {
+			cells[idx] = append(cells[idx], i)
+		}
+	}
+	if len(cells) <= MAX_SOLVER_COMPONENT {
+		return [][]solverConstraint{group}
+	}
+
+	result := make([][]solverConstraint, 0, len(group))
+	for i, c := range group {
+		overlapping := map[int]bool{i: true}
+		sub := []solverConstraint{c}
+		for _, idx := range c.cells {
+			for _, j := range cells[idx] {
+				if !overlapping[j] {
+					overlapping[j] = true
+					sub = append(sub, group[j])
+				}
+			}
+		}
+		result = append(result, sub)
+	}
+	return result
+}
+
+// constraints lists what the opened cells next to unknown cells tell.
+func (s *solver) constraints() []solverConstraint {
+	result := []solverConstraint{}
+	for idx, revealed := range s.revealed {
+		if !revealed {
+			continue
+		}
+
+		unknown := []int{}
+		knownMines := 0
+		for _, next := range s.neighbours(idx) {
+			switch {
+			case s.mine[next]:
+				knownMines++
+			case !s.revealed[next]:
+				unknown = append(unknown, next)
+			}
+		}
+		if len(unknown) == 0 {
+			continue
+		}
+
+		counts := []int{}
+		for _, count := range s.cellAt(idx).possibleMines() {
+			if count -= knownMines; count >= 0 && count <= len(unknown) {
+				counts = append(counts, count)
+			}
+		}
+		result = append(result, solverConstraint{
+			cells:  unknown,
+			counts: counts,
+		})
+	}
+	return result
+}
+
+// solveComponent enumerates the layouts of the unknown cells of a group of
+// constraints and returns the cells that are safe, or mines, in all of them.
+func (s *solver) solveComponent(constraints []solverConstraint) ([]int, []int) {
+	local := map[int]int{}
+	cells := []int{}
+	for _, c := range constraints {
+		for _, idx := range c.cells {
+			if _, ok := local[idx]; !ok {
+				local[idx] = len(cells)
+				cells = append(cells, idx)
+			}
+		}
+	}
+	if len(cells) > MAX_SOLVER_COMPONENT {
+		return nil, nil
+	}
+
+	// the constraints of each cell, along with the running sums
+	cellConstraints := make([][]int, len(cells))
+	minCount := make([]int, len(constraints))
+	maxCount := make([]int, len(constraints))
+	unassigned := make([]int, len(constraints))
+	sums := make([]int, len(constraints))
+	for i, c := range constraints {
+		if len(c.counts) == 0 {
+			return nil, nil
+		}
+		minCount[i], maxCount[i] = c.counts[0], c.counts[0]
+		for _, count := range c.counts {
+			if count < minCount[i] {
+				minCount[i] = count
+			}
+			if count > maxCount[i] {
+				maxCount[i] = count
+			}
+		}
+		unassigned[i] = len(c.cells)
+		for _, idx := range c.cells {
+			cellConstraints[local[idx]] = append(cellConstraints[local[idx]], i)
+		}
+	}
+
+	layout := make([]bool, len(cells))
+	mineCounts := make([]int, len(cells))
+	solutions := 0
+
+	var assign func(i int)
+	assign = func(i int) {
+		if i == len(cells) {
+			for j, c := range constraints {
+				if !containsInt(c.counts, sums[j]) {
+					return
+				}
+			}
+			solutions++
+			for j, isMine := range layout {
+				if isMine {
+					mineCounts[j]++
+				}
+			}
+			return
+		}
+
+		for _, isMine := range []bool{false, true} {
+			layout[i] = isMine
+			feasible := true
+			for _, j := range cellConstraints[i] {
+				unassigned[j]--
+				if isMine {
+					sums[j]++
+				}
+				if sums[j] > maxCount[j] || sums[j]+unassigned[j] < minCount[j] {
+					feasible = false
+				}
+			}
+			if feasible {
+				assign(i + 1)
+			}
+			for _, j := range cellConstraints[i] {
+				unassigned[j]++
+				if isMine {
+					sums[j]--
+				}
+			}
+		}
+	}
+	assign(0)
+
+	if solutions == 0 {
+		return nil, nil
+	}
+
+	safe := []int{}
+	mines := []int{}
+	for i, count := range mineCounts {
+		switch count {
+		case 0:
+			safe = append(safe, cells[i])
+		case solutions:
+			mines = append(mines, cells[i])
+		}
+	}
+	return safe, mines
+}
+
+func containsInt(values []int, val int) bool {
+	for _, v := range values {
+		if v == val {
+			return true
+		}
+	}
+	return false
+}
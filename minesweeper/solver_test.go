@@ -0,0 +1,116 @@
+package minesweeper
+
+import "testing"
+
+func layMines(f *Field, mines ...Location) {
+	for _, loc := range mines {
+		f.cells[loc.row][loc.col].isMine = true
+	}
+	f.minesCount = len(mines)
+	f.setAdjacentMinesCount()
+}
+
+func TestSolverNeedsGuess(t *testing.T) {
+	// the mine is either of the two cells of the last column
+	field := NewFieldBuilder().WithRow(2).WithCol(3).Build()
+	layMines(field, Location{row: 0, col: 2})
+
+	if field.isSolvable(Location{row: 0, col: 0}) {
+		t.Errorf("expected the board to need a guess")
+	}
+}
+
+func TestSolverDeducesMines(t *testing.T) {
+	// the 1 2 1 of the middle column gives both mines away, the cell between
+	// them is safe and opens the last column
+	field := NewFieldBuilder().WithRow(3).WithCol(5).Build()
+	layMines(field, Location{row: 0, col: 3}, Location{row: 2, col: 3})
+
+	if !field.isSolvable(Location{row: 1, col: 0}) {
+		t.Errorf("expected the board to be solvable")
+	}
+}
+
+// TestSolverIsSound plays random boards and checks that the solver never
+// deduces a wrong cell.
+func TestSolverIsSound(t *testing.T) {
+	for _, liar := range []bool{false, true} {
+		for i := 0; i < 50; i++ {
+			field := NewFieldBuilder().
+				WithRow(9).
+				WithCol(9).
+				WithMinesCount(10).
+				WithLiar(liar).
+				Build()
+			start := Location{row: 4, col: 4}
+			field.generateMines(start)
+
+			s := &solver{
+				f:        field,
+				revealed: make([]bool, 81),
+				mine:     make([]bool, 81),
+				safeLeft: 81 - 10,
+			}
+			s.reveal(start.row*9 + start.col)
+			for s.safeLeft > 0 && s.step() {
+			}
+
+			for idx := range s.revealed {
+				isMine := s.cellAt(idx).isMine
+				if s.revealed[idx] && isMine {
+					t.Fatalf("liar %v: the solver opened the mine at %d", liar, idx)
+				}
+				if s.mine[idx] && !isMine {
+					t.Fatalf("liar %v: the solver flagged the safe cell at %d", liar, idx)
+				}
+			}
+		}
+	}
+}
+
+func TestNoGuessLayout(t *testing.T) {
+	for _, liar := range []bool{false, true} {
+		field := NewFieldBuilder().
+			WithRow(9).
+			WithCol(9).
+			WithMinesCount(10).
+			WithLiar(liar).
+			WithNoGuess(true).
+			Build()
+
+		start := Location{row: 4, col: 4}
+		if err := field.generateMines(start); err != nil {
+			t.Errorf("liar %v: failed to lay out the board: %v", liar, err)
+		}
+		if !field.isSolvable(start) {
+			t.Errorf("liar %v: expected a board solvable without guessing", liar)
+		}
+	}
+}
+
+func TestNoGuessFailure(t *testing.T) {
+	// the first open shows a 1 next to three unknown cells
+	field := NewFieldBuilder().
+		WithRow(2).
+		WithCol(2).
+		WithMinesCount(1).
+		WithFirstClickPolicy(FIRST_CLICK_SAFE).
+		WithNoGuess(true).
+		Build()
+	if _, err := field.OpenCell(0, 0, "p1"); err != nil {
+		t.Fatalf("failed to open the first cell: %v", err)
+	}
+	if !field.NeedsGuess() {
+		t.Errorf("expected the board to need guessing")
+	}
+
+	large := NewFieldBuilder().
+		WithRow(MAX_NO_GUESS_CELLS).
+		WithCol(2).
+		WithMinesCount(10).
+		WithNoGuess(true).
+		Build()
+	if err := large.generateMines(Location{row: 0, col: 0}); err != ErrGuessNeeded {
+		t.Errorf("expected ErrGuessNeeded above MAX_NO_GUESS_CELLS, got %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package minesweeper
+
+import "github.com/aryuuu/mines-party-server/utils"
+
+const (
+	// DEFAULT_SPAWN_SECONDS is the time between two mine waves in survival
+	// mode
+	DEFAULT_SPAWN_SECONDS = 10
+	// DEFAULT_SPAWN_COUNT is the number of mines planted by a wave
+	DEFAULT_SPAWN_COUNT = 3
+	// DEFAULT_SPAWN_SAFE_RADIUS keeps the waves away from the cells the
+	// players just opened, in cells around them
+	DEFAULT_SPAWN_SAFE_RADIUS = 2
+	// DEFAULT_RECENT_OPENS is the number of opened cells the safe radius is
+	// kept around
+	DEFAULT_RECENT_OPENS = 8
+)
+
+// survivalScoring rewards the cells opened later in a survival game, the
+// points of the wrapped policy are multiplied by the waves survived so far.
+type survivalScoring struct {
+	base ScoringPolicy
+}
+
+func (s survivalScoring) Score(outcome ActionOutcome) int {
+	points := s.base.Score(outcome)
+	if outcome.HitMine || points <= 0 {
+		return points
+	}
+	return points * (1 + outcome.Wave)
+}
+
+// rememberOpen keeps track of the cells the players opened last, the mine
+// waves stay clear of them.
+func (f *Field) rememberOpen(row, col int) {
+	f.recentOpens = append(f.recentOpens, Location{row: row, col: col})
+	if len(f.recentOpens) > DEFAULT_RECENT_OPENS {
+		f.recentOpens = f.recentOpens[len(f.recentOpens)-DEFAULT_RECENT_OPENS:]
+	}
+}
+
+func (f *Field) isNearRecentOpen(row, col, radius int) bool {
+	for _, loc := range f.recentOpens {
+		if utils.Abs(loc.row-row) <= radius && utils.Abs(loc.col-col) <= radius {
+			return true
+		}
+	}
+	return false
+}
+
+// SpawnMines plants up to count new mines into random closed cells that are
+// not flagged and lie outside of safeRadius around the recently opened cells.
+// The opened cells around the new mines get their numbers updated. It returns
+// the number of mines planted, nothing is planted before the first open.
+func (f *Field) SpawnMines(count, safeRadius int) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	defer f.commit()
+
+	if !f.isStarted {
+		return 0
+	}
+
+	candidates := []Location{}
+	for i := 0; i < f.row; i++ {
+		for j := 0; j < f.col; j++ {
+			cell := f.cells[i][j]
+			if cell.isOpen || cell.isMine || cell.isFlagged {
+				continue
+			}
+			if f.isNearRecentOpen(i, j, safeRadius) {
+				continue
+			}
+			candidates = append(candidates, Location{row: i, col: j})
+		}
+	}
+
+	rng := f.getRand()
+	affected := map[Location]bool{}
+	planted := 0
+	for ; planted < count && planted < len(candidates); planted++ {
+		k := planted + rng.Intn(len(candidates)-planted)
+		candidates[planted], candidates[k] = candidates[k], candidates[planted]
+		loc := candidates[planted]
+
+		// a treasure taken by a mine is lost
+		f.cells[loc.row][loc.col].isMine = true
+		f.cells[loc.row][loc.col].treasure = 0
+		f.minesCount++
+		for _, next := range f.getNeighbours(loc.row, loc.col) {
+			cell := f.cells[next.row][next.col]
+			cell.adjacentMines++
+			if cell.isOpen && !cell.isMine {
+				affected[next] = true
+			}
+		}
+	}
+
+	for loc := range affected {
+		f.record(loc.row, loc.col, "")
+	}
+	f.spawnWaves++
+
+	return planted
+}
@@ -0,0 +1,92 @@
+package minesweeper_test
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/aryuuu/mines-party-server/minesweeper"
+)
+
+func TestSpawnMines(t *testing.T) {
+	field := minesweeper.NewFieldBuilder().
+		WithRow(20).
+		WithCol(20).
+		WithMinesCount(40).
+		WithSurvival(true).
+		Build()
+
+	if planted := field.SpawnMines(5, minesweeper.DEFAULT_SPAWN_SAFE_RADIUS); planted != 0 {
+		t.Fatalf("expected no mine before the first open, got %d", planted)
+	}
+
+	if _, err := field.OpenCell(10, 10, "p1"); err != nil {
+		t.Fatalf("failed to open cell: %v", err)
+	}
+	remaining := field.GetRemainingMines()
+	version := field.Version()
+
+	planted := field.SpawnMines(5, minesweeper.DEFAULT_SPAWN_SAFE_RADIUS)
+	if planted != 5 {
+		t.Fatalf("expected 5 mines planted, got %d", planted)
+	}
+	if got := field.GetRemainingMines(); got != remaining+planted {
+		t.Errorf("expected %d remaining mines, got %d", remaining+planted, got)
+	}
+	if _, ok := field.DiffSince(version); !ok {
+		t.Errorf("expected a diff for the wave")
+	}
+
+	// the numbers of the opened cells account for the new mines
+	assertNumbersMatchMines(t, field)
+}
+
+func TestGameRoomSpawnDefaults(t *testing.T) {
+	host := minesweeper.NewPlayer("host", "")
+	room := minesweeper.NewGameRoom("survive", host.PlayerID, 4)
+	room.AddPlayer(host)
+
+	// a spawn count left out falls back to the default
+	settings := room.GetSettings()
+	settings.Mode = minesweeper.MODE_SURVIVAL
+	settings.SpawnCount = 0
+	room.UpdateSettings(settings)
+	if err := room.Start(); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	if _, _, err := room.OpenCell(0, 0, host.PlayerID); err != nil && err != minesweeper.ErrOpenMine {
+		t.Fatalf("failed to open cell: %v", err)
+	}
+
+	planted, _, err := room.SpawnMines()
+	if err != nil || planted != minesweeper.DEFAULT_SPAWN_COUNT {
+		t.Errorf("expected %d mines planted, got %d %v", minesweeper.DEFAULT_SPAWN_COUNT, planted, err)
+	}
+}
+
+// assertNumbersMatchMines checks the numbers of every safe cell against the
+// mines around them.
+func assertNumbersMatchMines(t *testing.T, field *minesweeper.Field) {
+	t.Helper()
+
+	bare := *field.GetCellStringBare()
+	for i := range bare {
+		for j := range bare[i] {
+			if bare[i][j] == "X" {
+				continue
+			}
+
+			mines := 0
+			for di := -1; di <= 1; di++ {
+				for dj := -1; dj <= 1; dj++ {
+					r, c := i+di, j+dj
+					if r >= 0 && r < len(bare) && c >= 0 && c < len(bare[r]) && bare[r][c] == "X" {
+						mines++
+					}
+				}
+			}
+			if bare[i][j] != strconv.Itoa(mines) {
+				t.Errorf("cell (%d, %d) shows %s but has %d adjacent mines", i, j, bare[i][j], mines)
+			}
+		}
+	}
+}
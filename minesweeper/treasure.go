@@ -0,0 +1,99 @@
+package minesweeper
+
+import (
+	"strconv"
+
+	"github.com/aryuuu/mines-party-server/utils"
+)
+
+const (
+	// TREASURE_SYMBOL prefixes the number of an opened treasure cell
+	TREASURE_SYMBOL = "T"
+	// DEFAULT_TREASURE_RATIO is the fraction of the safe cells holding a
+	// treasure, when treasures are enabled
+	DEFAULT_TREASURE_RATIO = 0.02
+)
+
+// DEFAULT_TREASURE_VALUES are the points a treasure may be worth, each
+// treasure picks one at random.
+var DEFAULT_TREASURE_VALUES = []int{10, 25, 50}
+
+// TreasureClaim records who opened a treasure cell.
+type TreasureClaim struct {
+	Row      int    `json:"row"`
+	Col      int    `json:"col"`
+	Value    int    `json:"value"`
+	PlayerID string `json:"id_player"`
+}
+
+// treasureValue returns how an opened treasure cell is shown: the treasure
+// symbol followed by the number of adjacent mines.
+func treasureValue(adjacentMines uint8) string {
+	return TREASURE_SYMBOL + strconv.Itoa(int(adjacentMines))
+}
+
+// generateTreasures hides treasures among the given safe cells, it shuffles
+// the candidates in place.
+func (f *Field) generateTreasures(candidates []Location) {
+	if f.treasureRatio <= 0 || len(f.treasureValues) == 0 {
+		return
+	}
+
+	count := int(float64(len(candidates)) * f.treasureRatio)
+	if count > len(candidates) {
+		count = len(candidates)
+	}
+	rng := f.getRand()
+	for k := 0; k < count; k++ {
+		n := k + rng.Intn(len(candidates)-k)
+		candidates[k], candidates[n] = candidates[n], candidates[k]
+		loc := candidates[k]
+
+		f.cells[loc.row][loc.col].treasure = f.treasureValues[rng.Intn(len(f.treasureValues))]
+	}
+}
+
+// safeCellsAwayFrom returns the closed safe cells outside of the 3x3 around the
+// given location.
+func (f *Field) safeCellsAwayFrom(loc Location) []Location {
+	result := []Location{}
+	for i, row := range f.cells {
+		for j, cell := range row {
+			if cell.isMine || cell.isOpen {
+				continue
+			}
+			if utils.Abs(i-loc.row) <= 1 && utils.Abs(j-loc.col) <= 1 {
+				continue
+			}
+			result = append(result, Location{row: i, col: j})
+		}
+	}
+	return result
+}
+
+// claimTreasure credits the treasure of a newly opened cell to the opener.
+func (f *Field) claimTreasure(row, col int, outcome *ActionOutcome) {
+	value := f.cells[row][col].treasure
+	if value == 0 {
+		return
+	}
+
+	outcome.TreasurePoints += value
+	f.claims = append(f.claims, TreasureClaim{
+		Row:      row,
+		Col:      col,
+		Value:    value,
+		PlayerID: outcome.PlayerID,
+	})
+}
+
+// GetTreasureClaims returns the treasures opened so far, in the order they
+// were claimed.
+func (f *Field) GetTreasureClaims() []TreasureClaim {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	result := make([]TreasureClaim, len(f.claims))
+	copy(result, f.claims)
+	return result
+}
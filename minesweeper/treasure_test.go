@@ -0,0 +1,61 @@
+package minesweeper_test
+
+import (
+	"testing"
+
+	"github.com/aryuuu/mines-party-server/minesweeper"
+)
+
+func TestTreasureClaims(t *testing.T) {
+	// every safe cell but the 3x3 around the first click holds a treasure
+	field := minesweeper.NewFieldBuilder().
+		WithRow(10).
+		WithCol(10).
+		WithMinesCount(0).
+		WithTreasures(1, []int{10}).
+		Build()
+
+	points, err := field.OpenCell(5, 5, "p1")
+	if err != nil {
+		t.Fatalf("failed to open cell: %v", err)
+	}
+
+	claims := field.GetTreasureClaims()
+	if len(claims) != 10*10-9 {
+		t.Fatalf("expected %d treasures claimed, got %d", 10*10-9, len(claims))
+	}
+	for _, claim := range claims {
+		if claim.PlayerID != "p1" || claim.Value != 10 {
+			t.Errorf("unexpected claim %+v", claim)
+		}
+	}
+
+	// the cold open is not scored, the treasures are
+	if points != len(claims)*10 {
+		t.Errorf("expected %d points, got %d", len(claims)*10, points)
+	}
+
+	board := *field.GetCellString()
+	if board[0][0] != minesweeper.TREASURE_SYMBOL+"0" {
+		t.Errorf("expected an opened treasure, got %q", board[0][0])
+	}
+	if board[5][5] != "0" {
+		t.Errorf("expected no treasure next to the first click, got %q", board[5][5])
+	}
+}
+
+func TestTreasureRatioAboveOne(t *testing.T) {
+	field := minesweeper.NewFieldBuilder().
+		WithRow(5).
+		WithCol(5).
+		WithMinesCount(0).
+		WithTreasures(2, []int{10}).
+		Build()
+
+	if _, err := field.OpenCell(2, 2, "p1"); err != nil {
+		t.Fatalf("failed to open cell: %v", err)
+	}
+	if claims := field.GetTreasureClaims(); len(claims) != 5*5-9 {
+		t.Errorf("expected every safe cell to hold a treasure, got %d", len(claims))
+	}
+}
@@ -6,7 +6,6 @@ import (
 	"net/http"
 
 	"github.com/aryuuu/mines-party-server/usecases"
-	"github.com/aryuuu/mines-party-server/utils"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 )
@@ -22,21 +21,40 @@ func InitGameRouter(r *mux.Router, upgrader websocket.Upgrader, guc usecases.Gam
 		GameUsecase: guc,
 	}
 
-	go gameRouter.GameUsecase.RunSwitch()
-
 	r.HandleFunc("/create", gameRouter.HandleCreateRoom)
+	r.HandleFunc("/rooms", gameRouter.HandleListRooms).Methods("GET")
+	r.HandleFunc("/rooms/stream", gameRouter.HandleLobbyStream).Methods("GET")
+	r.HandleFunc("/matchmaking", gameRouter.HandleMatchmaking)
 	r.HandleFunc("/{roomID}", gameRouter.HandleGameEvent)
 }
 
 func (m GameRouter) HandleCreateRoom(w http.ResponseWriter, r *http.Request) {
-	ID := utils.GenRandomString(5)
+	ID, err := m.GameUsecase.ReserveRoomCode(clientIP(r))
+	if err == usecases.ErrTooManyRoomCodes {
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		log.Printf("failed to reserve a room code: %v", err)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
 	log.Printf("Create new room with ID: %s", ID)
 
-	// TODO: check for duplicate room ID
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprintf(w, "%s", ID)
 }
 
+func (m GameRouter) HandleMatchmaking(w http.ResponseWriter, r *http.Request) {
+	conn, err := m.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	m.GameUsecase.Matchmake(conn)
+}
+
 func (m GameRouter) HandleGameEvent(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	roomID := vars["roomID"]
@@ -47,5 +65,5 @@ func (m GameRouter) HandleGameEvent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	m.GameUsecase.Connect(conn, roomID)
+	m.GameUsecase.Connect(conn, roomID, clientIP(r))
 }
@@ -0,0 +1,31 @@
+package routes
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/aryuuu/mines-party-server/configs"
+)
+
+// clientIP returns the IP of the client, as reported by the reverse proxy
+// when the server sits behind one. The proxy appends the address it got the
+// request from to X-Forwarded-For, the entries before it come from the client
+// and cannot be trusted.
+func clientIP(r *http.Request) string {
+	if configs.Constant.TrustProxy {
+		if values := r.Header.Values("X-Forwarded-For"); len(values) > 0 {
+			forwarded := values[len(values)-1]
+			last := strings.TrimSpace(forwarded[strings.LastIndex(forwarded, ",")+1:])
+			if last != "" {
+				return last
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
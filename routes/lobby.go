@@ -0,0 +1,80 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/aryuuu/mines-party-server/events"
+)
+
+// lobbyKeepAlive is the interval of the comments keeping an idle lobby
+// stream open through the proxies
+const lobbyKeepAlive = 25 * time.Second
+
+// HandleListRooms returns the public rooms.
+func (m GameRouter) HandleListRooms(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(events.NewLobbySnapshot(m.GameUsecase.ListRooms())); err != nil {
+		log.Println("failed to write json:", err.Error())
+	}
+}
+
+// HandleLobbyStream streams the public rooms as server-sent events: a
+// snapshot of the rooms first, then every change to them.
+func (m GameRouter) HandleLobbyStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	// subscribing before taking the snapshot does not miss any change
+	updates, unsubscribe := m.GameUsecase.SubscribeLobby()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	snapshot := events.NewLobbySnapshot(m.GameUsecase.ListRooms())
+	if err := writeServerEvent(w, snapshot.EventType, snapshot); err != nil {
+		return
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(lobbyKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case update, ok := <-updates:
+			// the stream fell behind, or the server is shutting down, the
+			// client reconnects for a new snapshot
+			if !ok {
+				return
+			}
+			if err := writeServerEvent(w, update.EventType, update); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func writeServerEvent(w http.ResponseWriter, eventType events.EventType, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", eventType, payload)
+	return err
+}
@@ -0,0 +1,143 @@
+package usecases
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	// roomCodeAttempts is the number of codes generated before giving up on
+	// finding a free one
+	roomCodeAttempts = 20
+	// maxRoomCodes is the number of codes a client IP can reserve per
+	// roomCodeWindow
+	maxRoomCodes   = 10
+	roomCodeWindow = time.Minute
+)
+
+var errNoRoomCode = errors.New("no free room code")
+
+// ErrTooManyRoomCodes is returned to the client IPs reserving too many codes.
+var ErrTooManyRoomCodes = errors.New("too many room codes reserved, try again later")
+
+// blockedWords are never part of a room code
+var blockedWords = []string{
+	"anal", "anus", "ass", "bitch", "butt", "cock", "cum", "cunt", "dick",
+	"fag", "fuck", "kkk", "nazi", "nig", "penis", "piss", "poo",
+	"porn", "rape", "sex", "shit", "slut", "tit", "twat", "wank", "whore",
+}
+
+var (
+	codeAdjectives = []string{
+		"agile", "bold", "brave", "bright", "calm", "clever", "cosy", "crisp",
+		"daring", "eager", "fancy", "gentle", "glad", "grand", "happy", "jolly",
+		"keen", "kind", "lively", "lucky", "merry", "mighty", "neat", "noble",
+		"proud", "quick", "quiet", "rapid", "shy", "sunny", "swift", "witty",
+	}
+	codeAnimals = []string{
+		"badger", "beaver", "bison", "camel", "crane", "eagle", "falcon",
+		"ferret", "gecko", "heron", "ibis", "koala", "lemur", "llama", "lynx",
+		"marten", "moose", "newt", "otter", "owl", "panda", "puffin", "quail",
+		"raven", "robin", "seal", "stoat", "swan", "tapir", "toucan", "walrus",
+		"yak",
+	}
+)
+
+// roomCodes hands out the codes of the new rooms, reserving them against the
+// registry so that no two rooms get the same code.
+type roomCodes struct {
+	rooms    *roomRegistry
+	capacity int
+	alphabet string
+	length   int
+	// words generates codes like brave-otter-42 instead
+	words bool
+	// ttl is how long a code is held for its room to be created
+	ttl time.Duration
+}
+
+// reserve returns a free code, held until the ttl is over.
+func (rc *roomCodes) reserve() (string, error) {
+	for i := 0; i < roomCodeAttempts; i++ {
+		code, err := rc.generate()
+		if err != nil {
+			return "", err
+		}
+		if isBlocked(code) {
+			continue
+		}
+
+		err = rc.rooms.reserve(code, time.Now().Add(rc.ttl), rc.capacity)
+		if err != errRoomExists {
+			return code, err
+		}
+	}
+	return "", errNoRoomCode
+}
+
+func (rc *roomCodes) generate() (string, error) {
+	if rc.words {
+		return generateWordCode()
+	}
+
+	var code strings.Builder
+	for i := 0; i < rc.length; i++ {
+		n, err := randomInt(len(rc.alphabet))
+		if err != nil {
+			return "", err
+		}
+		code.WriteByte(rc.alphabet[n])
+	}
+	return code.String(), nil
+}
+
+func generateWordCode() (string, error) {
+	adjective, err := randomInt(len(codeAdjectives))
+	if err != nil {
+		return "", err
+	}
+	animal, err := randomInt(len(codeAnimals))
+	if err != nil {
+		return "", err
+	}
+	number, err := randomInt(90)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s-%s-%d", codeAdjectives[adjective], codeAnimals[animal], number+10), nil
+}
+
+// randomInt returns a uniform random number in [0, n).
+func randomInt(n int) (int, error) {
+	value, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+	return int(value.Int64()), nil
+}
+
+func isBlocked(code string) bool {
+	code = strings.ToLower(code)
+	for _, word := range blockedWords {
+		if strings.Contains(code, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReserveRoomCode returns the code of a new room, the room must be created
+// with it before the code expires.
+func (u *gameUsecase) ReserveRoomCode(clientIP string) (string, error) {
+	if u.draining.Load() {
+		return "", errShuttingDown
+	}
+	if !u.codeLimiter.take(clientIP) {
+		return "", ErrTooManyRoomCodes
+	}
+	return u.codes.reserve()
+}
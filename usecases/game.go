@@ -1,7 +1,11 @@
 package usecases
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/aryuuu/mines-party-server/configs"
@@ -12,42 +16,180 @@ import (
 
 const (
 	scoreUpdateInterval = 3 * time.Second
+	// defaultChunkRadius is the number of chunks sent around the origin when
+	// the client does not ask for a window
+	defaultChunkRadius = 2
+	// inviteTTL is how long the invites created by the hosts last
+	inviteTTL = 24 * time.Hour
 )
 
+// connection is the write side of a client socket, its write pump runs for
+// as long as the socket is open.
 type connection struct {
+	// ID is the player of the connection, only its room touches it
 	ID    string
 	Queue chan interface{}
+
+	// mu guards the fields below, the queue is never sent to once closed
+	mu       sync.Mutex
+	encoding events.BoardEncoding
+	closed   bool
+	// closeMessage is sent once the queue is closed, a normal closure when
+	// it is nil
+	closeMessage []byte
 }
 
 type gameUsecase struct {
-	ConnectionRooms   map[string]map[*websocket.Conn]*connection
-	GameRooms         map[string]*minesweeper.GameRoom
-	StopScoreCronChan map[string]chan bool
-	SwitchQueue       chan *events.SocketEvent
+	rooms *roomRegistry
+	// capacity is the maximum number of rooms
+	capacity int
+	// playerCapacity is the maximum number of players and spectators over
+	// all the rooms
+	playerCapacity int
+	// sessionGrace is how long a disconnected player is kept in its room
+	sessionGrace time.Duration
+	// pingInterval, pongWait, writeWait and maxMessageSize keep the
+	// websocket clients in check, see configs.Constant.PingInterval
+	pingInterval   time.Duration
+	pongWait       time.Duration
+	writeWait      time.Duration
+	maxMessageSize int64
+	// joinLimiter slows down the clients guessing room IDs, passwords or
+	// invites
+	joinLimiter *attemptLimiter
+	// codeLimiter keeps the clients from piling up room code reservations
+	codeLimiter *attemptLimiter
+	lobby       *lobby
+	matchmaker  *matchmaker
+	codes       *roomCodes
+
+	// draining refuses the new rooms, players and games once the server is
+	// shutting down
+	draining atomic.Bool
+	// pumps counts the running write pumps
+	pumps sync.WaitGroup
+	// clients are the open connections, closed by the shutdown once their
+	// rooms are closed, nil afterwards
+	clientsMu sync.Mutex
+	clients   map[*websocket.Conn]*connection
+	// shutdownNotice is how long the clients are warned before their rooms
+	// close, snapshotPath where the rooms are saved then
+	shutdownNotice time.Duration
+	snapshotPath   string
 }
 
 type GameUsecase interface {
-	Connect(conn *websocket.Conn, roomID string)
-	RunSwitch()
+	Connect(conn *websocket.Conn, roomID string, clientIP string)
+	ListRooms() []events.LobbyRoom
+	SubscribeLobby() (<-chan *events.LobbyUpdate, func())
+	Matchmake(conn *websocket.Conn)
+	ReserveRoomCode(clientIP string) (string, error)
+	Shutdown(ctx context.Context) error
 }
 
-func NewConnection(ID string) *connection {
+func NewConnection(ID string, encoding events.BoardEncoding) *connection {
 	return &connection{
-		ID:    ID,
-		Queue: make(chan interface{}, 256),
+		ID:       ID,
+		Queue:    make(chan interface{}, 256),
+		encoding: encoding,
+	}
+}
+
+// send queues the message for the client, waiting while the queue is full.
+// It returns false once the connection is closed.
+func (c *connection) send(message interface{}) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return false
+	}
+	if m, ok := message.(events.BoardEncoder); ok {
+		message = m.EncodeBoard(c.encoding)
+	}
+	c.Queue <- message
+	return true
+}
+
+// trySend is send without waiting for a client that does not keep up.
+func (c *connection) trySend(message interface{}) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return false
+	}
+	select {
+	case c.Queue <- message:
+		return true
+	default:
+		return false
 	}
 }
 
+func (c *connection) setEncoding(encoding events.BoardEncoding) {
+	c.mu.Lock()
+	c.encoding = encoding
+	c.mu.Unlock()
+}
+
+// close lets the write pump send what is left in the queue, then close the
+// socket with the close message. Only the first call has any effect.
+func (c *connection) close(closeMessage []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return
+	}
+	c.closed = true
+	c.closeMessage = closeMessage
+	close(c.Queue)
+}
+
 func NewGameUsecase() GameUsecase {
-	return &gameUsecase{
-		ConnectionRooms:   make(map[string]map[*websocket.Conn]*connection),
-		GameRooms:         make(map[string]*minesweeper.GameRoom),
-		SwitchQueue:       make(chan *events.SocketEvent, 256),
-		StopScoreCronChan: make(map[string]chan bool),
+	u := &gameUsecase{
+		rooms:          newRoomRegistry(),
+		capacity:       configs.Constant.Capacity,
+		playerCapacity: configs.Constant.PlayerCapacity,
+		sessionGrace:   configs.Constant.SessionGrace,
+		pingInterval:   configs.Constant.PingInterval,
+		pongWait:       configs.Constant.PongWait,
+		writeWait:      configs.Constant.WriteWait,
+		maxMessageSize: configs.Constant.MaxMessageSize,
+		shutdownNotice: configs.Constant.ShutdownNotice,
+		snapshotPath:   configs.Constant.SnapshotPath,
+		joinLimiter:    newAttemptLimiter(maxFailedJoins, failedJoinWindow),
+		codeLimiter:    newAttemptLimiter(maxRoomCodes, roomCodeWindow),
+		lobby:          newLobby(),
+		clients:        make(map[*websocket.Conn]*connection),
+	}
+	u.codes = &roomCodes{
+		rooms:    u.rooms,
+		capacity: u.capacity,
+		alphabet: configs.Constant.RoomCodeAlphabet,
+		length:   configs.Constant.RoomCodeLength,
+		words:    configs.Constant.RoomCodeWords,
+		ttl:      configs.Constant.RoomCodeTTL,
 	}
+	u.matchmaker = newMatchmaker(configs.Constant.MatchTimeout, u.startMatch)
+	go u.matchmaker.run()
+
+	return u
 }
 
-func (u *gameUsecase) Connect(conn *websocket.Conn, roomID string) {
+// Connect reads the events of a client and hands them over to the goroutine
+// of its room.
+func (u *gameUsecase) Connect(conn *websocket.Conn, roomID string, clientIP string) {
+	c, untrack, ok := u.trackClient(conn)
+	if !ok {
+		refuseClient(conn, u.writeWait)
+		return
+	}
+	// a stale client fails the read below and leaves through disconnectPlayer
+	defer untrack()
+	u.keepAlive(conn)
+
 	for {
 		var clientEvent events.ClientEvent
 		err := conn.ReadJSON(&clientEvent)
@@ -56,512 +198,1069 @@ func (u *gameUsecase) Connect(conn *websocket.Conn, roomID string) {
 			log.Print(err)
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure) {
 				log.Print("IsUnexpectedCloseError()", err)
-				u.kickPlayer(conn, roomID, clientEvent)
 			} else {
 				log.Printf("expected close error: %v", err)
 			}
+			// a client that left already is not in the room anymore
+			if r, ok := u.rooms.get(roomID); ok {
+				r.post(roomEvent{task: func() {
+					u.disconnectPlayer(r, conn)
+				}})
+			}
 			return
 		}
 
 		switch clientEvent.EventType {
 		case events.CreateRoomEvent:
-			u.createRoom(conn, roomID, clientEvent)
+			if u.draining.Load() {
+				c.send(events.NewFailCreateRoomUnicast("Server is shutting down"))
+				continue
+			}
+			u.createRoom(conn, c, roomID, clientEvent)
 		case events.JoinRoomEvent:
-			u.joinRoom(conn, roomID, clientEvent)
-		case events.GameLeftEvent:
-			u.kickPlayer(conn, roomID, clientEvent)
-		case events.KickPlayerEvent:
-			u.kickPlayer(conn, roomID, clientEvent)
-		case events.VoteKickIssuedEvent:
-			u.voteKickPlayer(roomID, clientEvent)
-		case events.StartGameEvent:
-			u.startGame(conn, roomID)
-		case events.FlagCellEvent:
-			u.flagCell(conn, roomID, clientEvent)
-		case events.OpenCellEvent:
-			u.openCell(conn, roomID, clientEvent)
-		case events.ChatEvent:
-			u.broadcastChat(conn, roomID, clientEvent)
-		case events.PositionUpdatedEvent:
-			u.broadcastPosition(conn, roomID, clientEvent)
-		case events.ChangeSettingsEvent:
-			u.changeSettings(conn, roomID, clientEvent)
+			if u.draining.Load() {
+				c.send(events.NewFailJoinRoomUnicast(events.ErrorCodeShuttingDown, "server is shutting down"))
+				continue
+			}
+			u.joinRoom(conn, c, roomID, clientIP, clientEvent)
+		case events.ResumeSessionEvent:
+			u.resumeSession(conn, c, roomID, clientEvent)
 		default:
-			// TODO: send some kind of error to the client
+			if r, ok := u.rooms.get(roomID); ok {
+				r.post(roomEvent{conn: conn, event: clientEvent})
+			}
 		}
 	}
 }
 
-func (u *gameUsecase) createRoom(conn *websocket.Conn, roomID string, clientEvent events.ClientEvent) {
-	log.Printf("Client trying to create a new room with ID %v", roomID)
+// handleRoomEvent runs on the goroutine of the room.
+func (u *gameUsecase) handleRoomEvent(r *room, event roomEvent) {
+	defer u.refreshLobby(r)
 
-	if len(u.ConnectionRooms) >= int(configs.Constant.Capacity) {
-		message := events.NewFailCreateRoomUnicast("Server is full")
-		u.pushUnicastMessage(roomID, conn, message)
+	if event.task != nil {
+		event.task()
 		return
 	}
 
-	_, ok := u.ConnectionRooms[roomID]
+	conn, clientEvent := event.conn, event.event
+	switch clientEvent.EventType {
+	case events.GameLeftEvent:
+		u.kickPlayer(r, conn, clientEvent)
+	case events.KickPlayerEvent:
+		u.kickPlayer(r, conn, clientEvent)
+	case events.VoteKickIssuedEvent:
+		u.voteKickPlayer(r, conn, clientEvent)
+	case events.PromoteSpectatorEvent:
+		u.promoteSpectator(r, conn, clientEvent)
+	case events.AssignTeamEvent:
+		u.assignTeam(r, conn, clientEvent)
+	case events.CreateInviteEvent:
+		u.createInvite(r, conn)
+	case events.StartGameEvent:
+		u.startGame(r, conn)
+	case events.FlagCellEvent:
+		u.flagCell(r, conn, clientEvent)
+	case events.OpenCellEvent:
+		u.openCell(r, conn, clientEvent)
+	case events.ChatEvent:
+		u.broadcastChat(r, conn, clientEvent)
+	case events.PositionUpdatedEvent:
+		u.broadcastPosition(r, conn, clientEvent)
+	case events.ChangeSettingsEvent:
+		u.changeSettings(r, conn, clientEvent)
+	case events.BoardSyncEvent:
+		u.syncBoard(r, conn, clientEvent)
+	case events.ChunksRequestEvent:
+		u.sendChunks(r, conn, clientEvent)
+	default:
+		// TODO: send some kind of error to the client
+	}
+}
 
-	if ok {
-		message := events.NewFailCreateRoomUnicast("Room already exists")
-		u.pushUnicastMessage(roomID, conn, message)
+func (u *gameUsecase) createRoom(conn *websocket.Conn, c *connection, roomID string, clientEvent events.ClientEvent) {
+	log.Printf("Client trying to create a new room with ID %v", roomID)
+
+	if !u.rooms.reserveMember(u.playerCapacity) {
+		c.send(events.NewFailCreateRoomUnicast("Server is full"))
 		return
 	}
 
 	player := minesweeper.NewPlayer(clientEvent.ClientName, clientEvent.AvatarURL)
-	u.createConnectionRoom(roomID)
-	u.createGameRoom(roomID, player.PlayerID)
-	u.registerPlayer(roomID, conn, player)
+	r := newRoom(roomID, player.PlayerID, defaultRoomCapacity)
+	if clientEvent.Password != "" {
+		settings := r.GameRoom.GetSettings()
+		settings.Password = &clientEvent.Password
+		r.GameRoom.UpdateSettings(settings)
+	}
+	err := u.openRoom(r)
+	if err != nil {
+		u.rooms.releaseMember()
+	}
+	if err == errServerFull {
+		c.send(events.NewFailCreateRoomUnicast("Server is full"))
+		return
+	}
+	if err == errRoomExists {
+		c.send(events.NewFailCreateRoomUnicast("Room already exists"))
+		return
+	}
+	if err == errNotReserved {
+		c.send(events.NewFailCreateRoomUnicast("Room code is not reserved"))
+		return
+	}
+
+	r.post(roomEvent{task: func() {
+		u.registerPlayer(r, conn, c, player, events.ParseBoardEncoding(clientEvent.BoardEncoding))
 
-	res := events.NewRoomCreatedUnicast(u.GameRooms[roomID], "Room created successfully")
-	u.pushUnicastMessage(roomID, conn, res)
+		res := events.NewRoomCreatedUnicast(r.GameRoom, "Room created successfully", signSession(r.ID, player.PlayerID))
+		r.pushUnicastMessage(conn, res)
+	}})
 }
 
-func (u *gameUsecase) joinRoom(conn *websocket.Conn, roomID string, clientEvent events.ClientEvent) {
+// openRoom registers the room and starts its goroutine.
+func (u *gameUsecase) openRoom(r *room) error {
+	if err := u.rooms.add(r, u.capacity); err != nil {
+		return err
+	}
+	go r.run(u.handleRoomEvent)
+	return nil
+}
+
+func (u *gameUsecase) joinRoom(conn *websocket.Conn, c *connection, roomID string, clientIP string, clientEvent events.ClientEvent) {
 	log.Printf("Client trying to join room %v", roomID)
 
-	_, ok := u.ConnectionRooms[roomID]
+	if !u.joinLimiter.allow(clientIP) {
+		log.Printf("too many failed attempts from %s", clientIP)
+		c.send(events.NewFailJoinRoomUnicast(events.ErrorCodeRateLimited, "too many failed attempts, try again later"))
+		return
+	}
+
+	r, ok := u.rooms.get(roomID)
+	if ok {
+		ok = r.post(roomEvent{task: func() {
+			u.addPlayer(r, conn, c, clientIP, clientEvent)
+		}})
+	}
 	if !ok {
 		log.Printf("room %v does not exist", roomID)
-		res := events.NewFailJoinRoomUnicast(roomID, "room does not exist")
-		conn.WriteJSON(res)
+		u.joinLimiter.fail(clientIP)
+		c.send(events.NewFailJoinRoomUnicast(events.ErrorCodeRoomNotFound, "room does not exist"))
+	}
+}
+
+func (u *gameUsecase) addPlayer(r *room, conn *websocket.Conn, c *connection, clientIP string, clientEvent events.ClientEvent) {
+	gameRoom := r.GameRoom
+	if _, ok := r.getPlayerID(conn); ok {
+		res := events.NewFailJoinRoomUnicast(events.ErrorCodeAlreadyJoined, "already in the room")
+		c.send(res)
 		return
 	}
 
-	gameRoom := u.GameRooms[roomID]
-	if gameRoom.IsUsernameExist(clientEvent.ClientName) {
-		log.Printf("username %s already exist", clientEvent.ClientName)
-		res := events.NewFailJoinRoomUnicast(roomID, "username already exist")
-		conn.WriteJSON(res)
+	if err := u.checkAccess(r, clientEvent); err != nil {
+		log.Printf("client %s is denied access to room %s: %v", clientIP, r.ID, err)
+		u.joinLimiter.fail(clientIP)
+		res := events.NewFailJoinRoomUnicast(events.ErrorCodeOf(err), err.Error())
+		c.send(res)
+		return
+	}
+
+	if !u.rooms.reserveMember(u.playerCapacity) {
+		res := events.NewFailJoinRoomUnicast(events.ErrorCodeServerFull, "server is full")
+		c.send(res)
 		return
 	}
 
 	player := minesweeper.NewPlayer(clientEvent.ClientName, clientEvent.AvatarURL)
-	u.registerPlayer(roomID, conn, player)
+	if err := gameRoom.Join(player, clientEvent.Spectate); err != nil {
+		log.Printf("player %s cannot join room %s: %v", clientEvent.ClientName, r.ID, err)
+		u.rooms.releaseMember()
+		res := events.NewFailJoinRoomUnicast(events.ErrorCodeOf(err), err.Error())
+		c.send(res)
+		return
+	}
+	u.bindConn(r, conn, c, player.PlayerID, events.ParseBoardEncoding(clientEvent.BoardEncoding))
 
-	res := events.NewRoomJoinedUnicast(player.PlayerID, gameRoom)
-	u.pushUnicastMessage(roomID, conn, res)
+	res := events.NewRoomJoinedUnicast(player.PlayerID, gameRoom, signSession(r.ID, player.PlayerID))
+	r.pushUnicastMessage(conn, res)
 
-	broadcast := events.NewRoomJoinedBroadcast(player)
-	u.pushBroadcastMessage(roomID, broadcast)
+	var broadcast *events.RoomJoinedBroacast
+	if clientEvent.Spectate {
+		broadcast = events.NewSpectatorJoinedBroadcast(player)
+	} else {
+		broadcast = events.NewRoomJoinedBroadcast(player)
+	}
+	r.pushBroadcastMessage(broadcast)
 }
 
-func (u *gameUsecase) kickPlayer(conn *websocket.Conn, roomID string, clientEvent events.ClientEvent) {
-	log.Printf("Client trying to leave room %v", roomID)
+// checkAccess lets in the clients with a valid invite, or else with the
+// password of the room unless the room is invite only.
+func (u *gameUsecase) checkAccess(r *room, clientEvent events.ClientEvent) error {
+	if clientEvent.InviteToken != "" && verifyInvite(r, clientEvent.InviteToken) {
+		return nil
+	}
+	if r.GameRoom.GetSettings().InviteOnly {
+		return minesweeper.ErrInviteRequired
+	}
+	if !r.GameRoom.CheckPassword(clientEvent.Password) {
+		return minesweeper.ErrWrongPassword
+	}
+	return nil
+}
 
-	var playerID string
+// createInvite gives the host a token letting anyone into the room until it
+// expires.
+func (u *gameUsecase) createInvite(r *room, conn *websocket.Conn) {
+	playerID, _ := r.getPlayerID(conn)
+	if !r.GameRoom.IsHost(playerID) {
+		r.pushUnicastMessage(conn, events.NewFailCreateInviteUnicast("Only host can create invites"))
+		return
+	}
 
-	if clientEvent.PlayerID == "" {
-		player := u.ConnectionRooms[roomID][conn]
-		if player != nil {
-			playerID = u.ConnectionRooms[roomID][conn].ID
-		}
-		delete(u.ConnectionRooms[roomID], conn)
-		if u.GameRooms[roomID] != nil {
-			delete(u.GameRooms[roomID].Players, playerID)
-		}
-		log.Printf("delete player %s from room %s", playerID, roomID)
-	} else {
-		playerID = clientEvent.PlayerID
-		room := u.GameRooms[roomID]
-		if room == nil {
-			res := events.NewVoteKickPlayerUnicast(false)
-			u.pushUnicastMessage(roomID, conn, res)
-			return
-		}
+	expiresAt := time.Now().Add(inviteTTL)
+	r.pushUnicastMessage(conn, events.NewInviteCreatedUnicast(signInvite(r, expiresAt), expiresAt))
+}
+
+func (u *gameUsecase) kickPlayer(r *room, conn *websocket.Conn, clientEvent events.ClientEvent) {
+	log.Printf("Client trying to leave room %v", r.ID)
 
-		_, ok := room.Players[playerID]
+	if clientEvent.PlayerID == "" {
+		playerID, ok := r.getPlayerID(conn)
 		if !ok {
-			res := events.NewVoteKickPlayerUnicast(false)
-			u.pushUnicastMessage(roomID, conn, res)
 			return
 		}
 
-		res := events.NewVoteKickPlayerUnicast(true)
-		u.pushUnicastMessage(roomID, conn, res)
+		res := events.NewGameLeftUnicast(true)
+		r.pushUnicastMessage(conn, res)
 
-		u.GameRooms[roomID].VoteBallot[playerID] = 0
-		issuerID := u.ConnectionRooms[roomID][conn].ID
-		voteKickBroadcast := events.NewVoteKickPlayerBroadcast(playerID, issuerID)
-		u.pushBroadcastMessage(roomID, voteKickBroadcast)
+		u.unregisterPlayer(r, conn, playerID)
+		log.Printf("delete player %s from room %s", playerID, r.ID)
 		return
 	}
 
-	_, ok := u.ConnectionRooms[roomID]
-	res := events.NewGameLeftUnicast(true)
-	u.pushUnicastMessage(roomID, conn, res)
-
-	if ok {
-		broadcast := events.NewGameLeftBroadcast(playerID)
-		u.pushBroadcastMessage(roomID, broadcast)
-	}
+	playerID := clientEvent.PlayerID
+	gameRoom := r.GameRoom
 
-	gameRoom := u.GameRooms[roomID]
-	if gameRoom == nil {
+	// spectators can neither be kicked nor kick
+	issuerID, _ := r.getPlayerID(conn)
+	_, isPlayer := gameRoom.GetPlayer(issuerID)
+	_, ok := gameRoom.GetPlayer(playerID)
+	if !ok || !isPlayer {
+		res := events.NewVoteKickPlayerUnicast(false)
+		r.pushUnicastMessage(conn, res)
 		return
 	}
 
-	// appoint new host if necessary
-	if gameRoom.Settings.HostID == playerID {
-		newHostID := gameRoom.PickRandomHost()
-		changeHostBroadcast := events.NewChangeHostBroadcast(newHostID)
-		u.pushBroadcastMessage(roomID, changeHostBroadcast)
-	}
+	res := events.NewVoteKickPlayerUnicast(true)
+	r.pushUnicastMessage(conn, res)
 
-	if gameRoom.IsEmpty() {
-		if ch, ok := u.StopScoreCronChan[roomID]; ok {
-			ch <- true
-		}
-		delete(u.GameRooms, roomID)
-		delete(u.ConnectionRooms, roomID)
-		log.Printf("delete room %v", roomID)
-	}
+	gameRoom.OpenBallot(playerID)
+	voteKickBroadcast := events.NewVoteKickPlayerBroadcast(playerID, issuerID)
+	r.pushBroadcastMessage(voteKickBroadcast)
 }
 
-func (u *gameUsecase) voteKickPlayer(roomID string, clientEvent events.ClientEvent) {
-	log.Printf("Client is voting on room %v", roomID)
-	gameRoom := u.GameRooms[roomID]
+func (u *gameUsecase) voteKickPlayer(r *room, conn *websocket.Conn, clientEvent events.ClientEvent) {
+	log.Printf("Client is voting on room %v", r.ID)
+	gameRoom := r.GameRoom
 
-	_, ok := gameRoom.VoteBallot[clientEvent.PlayerID]
-	if !ok {
+	voterID, _ := r.getPlayerID(conn)
+	if _, ok := gameRoom.GetPlayer(voterID); !ok {
 		return
 	}
 
-	if clientEvent.AgreeToKick {
-		gameRoom.VoteBallot[clientEvent.PlayerID]++
+	tally, passed, ok := gameRoom.CastVote(clientEvent.PlayerID, voterID, clientEvent.AgreeToKick)
+	if !ok {
+		return
 	}
-	log.Printf("current tally %v", gameRoom.VoteBallot[clientEvent.PlayerID])
+	log.Printf("current tally %v", tally)
 
-	if clientEvent.AgreeToKick && gameRoom.VoteBallot[clientEvent.PlayerID] > len(gameRoom.Players)/2 {
+	if passed {
 		log.Printf("vote kick success, removing player")
-		delete(gameRoom.VoteBallot, clientEvent.PlayerID)
-
-		var targetConn *websocket.Conn
-		connRoom := u.ConnectionRooms[roomID]
 
-		for key, val := range connRoom {
-			if val.ID == clientEvent.PlayerID {
-				targetConn = key
-				break
-			}
+		// a disconnected player has no connection to notify
+		if targetConn, ok := r.findConn(clientEvent.PlayerID); ok {
+			evictionNotice := events.NewGameLeftUnicast(true)
+			r.pushUnicastMessage(targetConn, evictionNotice)
+			r.removeConn(targetConn)
 		}
-
-		if targetConn == nil {
-			return
-		}
-
-		evictionNotice := events.NewGameLeftUnicast(true)
-		u.pushUnicastMessage(roomID, targetConn, evictionNotice)
-
-		broadcast := events.NewGameLeftBroadcast(clientEvent.PlayerID)
-		u.pushBroadcastMessage(roomID, broadcast)
-
-		// appoint new host if necessary
-		if gameRoom.Settings.HostID == clientEvent.PlayerID {
-			newHostID := gameRoom.PickRandomHost()
-			changeHostBroadcast := events.NewChangeHostBroadcast(newHostID)
-			u.pushBroadcastMessage(roomID, changeHostBroadcast)
+		if player, ok := gameRoom.GetPlayer(clientEvent.PlayerID); ok {
+			gameRoom.Ban(player.Name)
 		}
+		r.stopGrace(clientEvent.PlayerID)
+		u.leaveRoom(r, clientEvent.PlayerID)
 	}
 }
 
-func (u *gameUsecase) getPlayerID(roomID string, conn *websocket.Conn) (string, bool) {
-	cRoom, ok := u.ConnectionRooms[roomID]
-	if !ok {
-		return "", ok
-	}
-	pConn, ok := cRoom[conn]
-	if !ok {
-		return "", ok
-	}
+func (u *gameUsecase) startGame(r *room, conn *websocket.Conn) {
+	log.Printf("Client trying to start game on room %v", r.ID)
+	gameRoom := r.GameRoom
+	playerID, _ := r.getPlayerID(conn)
 
-	return pConn.ID, ok
-}
-
-func (u *gameUsecase) startGame(conn *websocket.Conn, roomID string) {
-	log.Printf("Client trying to start game on room %v", roomID)
-	gameRoom := u.GameRooms[roomID]
-	playerID, _ := u.getPlayerID(roomID, conn)
-
-	if playerID != gameRoom.Settings.HostID {
+	if !gameRoom.IsHost(playerID) {
 		res := events.NewGameStartedUnicast(false, "Only host can start the game")
-		u.pushUnicastMessage(roomID, conn, res)
+		r.pushUnicastMessage(conn, res)
 		return
 	}
 
-	if gameRoom.IsStarted {
+	if gameRoom.HasStarted() {
 		res := events.NewGameStartedUnicast(false, "Game already started")
-		u.pushUnicastMessage(roomID, conn, res)
+		r.pushUnicastMessage(conn, res)
+		return
+	}
+
+	// the game would be cut short
+	if u.draining.Load() {
+		res := events.NewGameStartedUnicast(false, "Server is shutting down")
+		r.pushUnicastMessage(conn, res)
 		return
 	}
 
-	if len(gameRoom.Players) < 1 {
+	if gameRoom.PlayerCount() < 1 {
 		res := events.NewGameStartedUnicast(false, "Not enough players to start the game")
-		u.pushUnicastMessage(roomID, conn, res)
+		r.pushUnicastMessage(conn, res)
 		return
 	}
 
 	err := gameRoom.Start()
 	if err != nil {
 		res := events.NewGameStartedUnicast(false, err.Error())
-		u.pushUnicastMessage(roomID, conn, res)
+		r.pushUnicastMessage(conn, res)
 		return
 	}
-	u.setupScoreCron(roomID)
+	u.setupScoreCron(r)
+	settings := gameRoom.GetSettings()
+	switch settings.Mode {
+	case minesweeper.MODE_SURVIVAL:
+		interval := time.Duration(settings.SpawnSeconds) * time.Second
+		if interval <= 0 {
+			interval = minesweeper.DEFAULT_SPAWN_SECONDS * time.Second
+		}
+		u.setupRoomTicker(r, interval, func() {
+			u.spawnMines(r)
+		})
+	case minesweeper.MODE_EXPANDING:
+		if settings.GrowSeconds > 0 {
+			u.setupRoomTicker(r, time.Duration(settings.GrowSeconds)*time.Second, func() {
+				u.growBoard(r, gameRoom.Grow)
+			})
+		}
+	}
 
 	notifContent := "game started"
 	notification := events.NewNotificationBroadcast(notifContent)
 	// TODO: broadcast game started, with the fields and everything
-	res := events.NewGameStartedBroadcast(true, "Game started", gameRoom.Field.GetCellString())
+	board, version := gameRoom.GetSnapshot()
+	var res *events.GameStartedBroadcast
+	if _, ok := gameRoom.GetEndless(); ok {
+		res = events.NewEndlessGameStartedBroadcast("Game started", version)
+	} else {
+		remainingMines, _ := gameRoom.GetRemainingMines()
+		res = events.NewGameStartedBroadcast(true, "Game started", board, version, remainingMines)
+		res.Mode = settings.Mode
+	}
 
-	u.pushBroadcastMessage(roomID, res)
-	u.pushBroadcastMessage(roomID, notification)
+	r.pushBroadcastMessage(res)
+	r.pushBroadcastMessage(notification)
 }
 
-func (u *gameUsecase) flagCell(conn *websocket.Conn, roomID string, gameRequest events.ClientEvent) {
-	gameRoom := u.GameRooms[roomID]
+func (u *gameUsecase) flagCell(r *room, conn *websocket.Conn, gameRequest events.ClientEvent) {
+	gameRoom := r.GameRoom
 	// TODO: maybe add flag log with the player id in it
-	// playerID := u.ConnectionRooms[roomID][conn].ID
-	if !gameRoom.IsStarted {
+	playerID, _ := r.getPlayerID(conn)
+
+	player, ok := gameRoom.GetPlayer(playerID)
+	if !ok {
+		u.rejectSpectator(r, conn, playerID)
+		return
+	}
+	if !u.checkCanAct(r, conn, player) {
+		return
+	}
+
+	diff, err := gameRoom.FlagCell(gameRequest.Row, gameRequest.Col, playerID)
+	if err == minesweeper.ErrGameNotStarted {
 		log.Printf("game is not started")
 		// i guess we don't need to send any response here, just like a real minesweeper game
 		return
 	}
-
-	var boardUpdatedBroadcast events.BoardUpdatedBroadcast
-
-	playerID, _ := u.getPlayerID(roomID, conn)
-	err := gameRoom.FlagCell(gameRequest.Row, gameRequest.Col, playerID)
 	if err != nil {
 		log.Printf("error flagging cell: %v", err)
-		// TODO: send error response
+		r.pushUnicastMessage(conn, events.NewErrorUnicast(err))
 		return
 	}
 
-	boardUpdatedBroadcast = *events.NewBoardUpdatedBroadcast(gameRoom.Field.GetCellString())
-
 	// TODO: update the score
 
-	u.pushBroadcastMessage(roomID, boardUpdatedBroadcast)
+	r.pushBroadcastMessage(events.NewBoardDiffBroadcast(diff))
 }
 
-func (u *gameUsecase) openCell(conn *websocket.Conn, roomID string, gameRequest events.ClientEvent) {
-	gameRoom := u.GameRooms[roomID]
+func (u *gameUsecase) openCell(r *room, conn *websocket.Conn, gameRequest events.ClientEvent) {
+	gameRoom := r.GameRoom
 	// TODO: maybe add open log with the player id in it
-	// playerID := u.ConnectionRooms[roomID][conn].ID
-	if !gameRoom.IsStarted {
-		log.Printf("game is not started")
-		// i guess we don't need to send any response here, just like a real minesweeper game
+	playerID, _ := r.getPlayerID(conn)
+
+	player, ok := gameRoom.GetPlayer(playerID)
+	if !ok {
+		u.rejectSpectator(r, conn, playerID)
+		return
+	}
+	if !u.checkCanAct(r, conn, player) {
 		return
 	}
 
-	playerID, _ := u.getPlayerID(roomID, conn)
-
-	var boardUpdatedBroadcast events.BoardUpdatedBroadcast
+	// the first open lays out the mines of the board
+	field := gameRoom.GetField()
+	layingOut := field != nil && field.GetOpenCellCount() == 0
 
-	player := gameRoom.Players[playerID]
-	points, err := gameRoom.OpenCell(gameRequest.Row, gameRequest.Col, playerID)
-	if err != nil && err == minesweeper.ErrOpenMine {
+	points, diff, err := gameRoom.OpenCell(gameRequest.Row, gameRequest.Col, playerID)
+	if err == minesweeper.ErrGameNotStarted {
+		log.Printf("game is not started")
+		// i guess we don't need to send any response here, just like a real minesweeper game
+		return
+	}
+	if layingOut && field.NeedsGuess() {
+		r.pushBroadcastMessage(events.NewNotificationBroadcast("the board could not be laid out without guessing, some guesses may be needed"))
+	}
+	if err != nil && err != minesweeper.ErrOpenMine {
+		log.Printf("error opening cell: %v", err)
+		r.pushUnicastMessage(conn, events.NewErrorUnicast(err))
+		return
+	}
+	if err == minesweeper.ErrOpenMine {
 		log.Printf("error opening cell: %v", err)
+		if !u.penalizeMineHit(r, player, points) {
+			u.endGameOnMine(r, player)
+			return
+		}
+	} else {
 		player.AddScore(points)
-		u.updateScore(roomID, time.Now().Unix())
-		gameRoom.End()
-		mineOpened := events.NewMinesOpenedBroadcast(gameRoom.Field.GetCellStringBare(), gameRoom.Players)
-		u.pushBroadcastMessage(roomID, mineOpened)
+	}
 
-		notifContent := player.Name + " opened a mine, boo!"
-		notification := events.NewNotificationBroadcast(notifContent)
-		u.pushBroadcastMessage(roomID, notification)
+	if diff != nil && len(diff.Changes) > 0 {
+		r.pushBroadcastMessage(events.NewBoardDiffBroadcast(diff))
+	}
 
+	if !gameRoom.IsCleared() {
 		return
 	}
-	player.AddScore(points)
 
-	boardUpdatedBroadcast = *events.NewBoardUpdatedBroadcast(gameRoom.Field.GetCellString())
-	u.pushBroadcastMessage(roomID, boardUpdatedBroadcast)
+	// an expanding board grows until it cannot anymore, the board may also
+	// have been grown by a concurrent last sweep
+	if gameRoom.GetSettings().Mode == minesweeper.MODE_EXPANDING {
+		err := u.growBoard(r, gameRoom.GrowIfCleared)
+		if err == nil || err == minesweeper.ErrNotCleared {
+			return
+		}
+	}
 
-	if gameRoom.Field.IsCleared() {
+	if gameRoom.End() == nil {
 		log.Printf("game is cleared")
-		u.updateScore(roomID, time.Now().Unix())
-		gameRoom.End()
+		u.updateScore(r, time.Now().Unix())
 
 		notifContent := "mines are cleared, " + player.Name + " with the last sweep!"
 		notification := events.NewNotificationBroadcast(notifContent)
-		u.pushBroadcastMessage(roomID, notification)
+		r.pushBroadcastMessage(notification)
 
-		res := events.NewGameClearedBroadcast(gameRoom.Field.GetCellStringBare(), gameRoom.Players)
-		u.pushBroadcastMessage(roomID, res)
+		res := events.NewGameClearedBroadcast(gameRoom.GetCellStringBare(), gameRoom.GetPlayers())
+		r.pushBroadcastMessage(res)
+		u.sendTreasureSummary(r)
 	}
 }
 
-func (u *gameUsecase) broadcastChat(conn *websocket.Conn, roomID string, gameRequest events.ClientEvent) {
-	log.Printf("Client is sending chat on room %v", roomID)
+// rejectSpectator tells a spectator trying to play that it cannot.
+func (u *gameUsecase) rejectSpectator(r *room, conn *websocket.Conn, playerID string) {
+	if r.GameRoom.IsSpectator(playerID) {
+		r.pushUnicastMessage(conn, events.NewErrorUnicast(minesweeper.ErrSpectator))
+	}
+}
 
-	room, ok := u.ConnectionRooms[roomID]
-	if ok {
-		playerID := room[conn].ID
-		playerName := u.GameRooms[roomID].Players[playerID].Name
+// checkCanAct tells whether the player can act on the board, sending the
+// reason to the client when it cannot.
+func (u *gameUsecase) checkCanAct(r *room, conn *websocket.Conn, player *minesweeper.Player) bool {
+	remaining, err := player.CanAct()
+	if err == nil {
+		return true
+	}
 
-		log.Printf("player %s send chat", playerName)
-		broadcast := events.NewMessageBroadcast(gameRequest.Message, playerName)
-		u.pushBroadcastMessage(roomID, broadcast)
+	if err == minesweeper.ErrPlayerFrozen {
+		r.pushUnicastMessage(conn, events.NewCooldownErrorUnicast(err, remaining))
+	} else {
+		r.pushUnicastMessage(conn, events.NewErrorUnicast(err))
 	}
+	return false
 }
 
-func (u *gameUsecase) broadcastPosition(conn *websocket.Conn, roomID string, gameRequest events.ClientEvent) {
-	room, ok := u.ConnectionRooms[roomID]
-	if ok {
-		playerID := room[conn].ID
+// penalizeMineHit applies the mine hit policy of the room to the player and
+// returns whether the game goes on.
+func (u *gameUsecase) penalizeMineHit(r *room, player *minesweeper.Player, points int) bool {
+	gameRoom := r.GameRoom
+	settings := gameRoom.GetSettings()
+
+	var frozenUntil time.Time
+	var notifContent string
+	switch settings.MineHitPolicy {
+	case minesweeper.MINE_HIT_FREEZE:
+		points = 0
+		freezeSeconds := settings.FreezeSeconds
+		if freezeSeconds <= 0 {
+			freezeSeconds = minesweeper.DEFAULT_FREEZE_SECONDS
+		}
+		frozenUntil = player.Freeze(time.Duration(freezeSeconds) * time.Second)
+		notifContent = fmt.Sprintf("%s opened a mine and is frozen for %d seconds", player.Name, freezeSeconds)
+	case minesweeper.MINE_HIT_DEDUCT:
+		player.AddScore(points)
+		notifContent = player.Name + " opened a mine, boo!"
+	case minesweeper.MINE_HIT_ELIMINATE:
+		player.AddScore(points)
+		player.Eliminate()
+		if !gameRoom.HasActivePlayers() {
+			return false
+		}
+		notifContent = player.Name + " opened a mine and is out!"
+	default:
+		player.AddScore(points)
+		return false
+	}
+
+	penalty := events.NewPenaltyBroadcast(player.PlayerID, settings.MineHitPolicy, points, frozenUntil)
+	r.pushBroadcastMessage(penalty)
+
+	notification := events.NewNotificationBroadcast(notifContent)
+	r.pushBroadcastMessage(notification)
 
-		broadcast := events.NewPositionUpdateBroadcast(playerID, gameRequest.Row, gameRequest.Col)
-		u.pushBroadcastMessage(roomID, broadcast)
+	return true
+}
+
+func (u *gameUsecase) endGameOnMine(r *room, player *minesweeper.Player) {
+	gameRoom := r.GameRoom
+	if gameRoom.End() != nil {
+		// someone else already ended the game
+		return
 	}
+	u.updateScore(r, time.Now().Unix())
+	mineOpened := events.NewMinesOpenedBroadcast(gameRoom.GetCellStringBare(), gameRoom.GetPlayers())
+	r.pushBroadcastMessage(mineOpened)
+
+	notifContent := player.Name + " opened a mine, boo!"
+	if endless, ok := gameRoom.GetEndless(); ok {
+		notifContent += fmt.Sprintf(" The group pushed %d cells out.", endless.GetMaxDistance())
+	}
+	notification := events.NewNotificationBroadcast(notifContent)
+	r.pushBroadcastMessage(notification)
+	u.sendTreasureSummary(r)
+}
+
+// sendTreasureSummary tells everyone who claimed which treasures once a game
+// with treasures ends.
+func (u *gameUsecase) sendTreasureSummary(r *room) {
+	gameRoom := r.GameRoom
+	if gameRoom.GetSettings().TreasureRatio <= 0 {
+		return
+	}
+
+	summary := events.NewTreasureSummaryBroadcast(gameRoom.GetTreasureClaims())
+	r.pushBroadcastMessage(summary)
+}
+
+// syncBoard brings a client that missed some board versions up to date, with a
+// diff when the missing versions are still known or with the full board.
+func (u *gameUsecase) syncBoard(r *room, conn *websocket.Conn, gameRequest events.ClientEvent) {
+	gameRoom := r.GameRoom
+
+	if diff, ok := gameRoom.DiffSince(gameRequest.Version); ok {
+		r.pushUnicastMessage(conn, events.NewBoardDiffBroadcast(diff))
+		return
+	}
+
+	// there is no full board in endless mode, resend the client's chunks
+	if _, ok := gameRoom.GetEndless(); ok {
+		u.sendChunks(r, conn, gameRequest)
+		return
+	}
+
+	board, version := gameRoom.GetSnapshot()
+	remainingMines, _ := gameRoom.GetRemainingMines()
+	r.pushUnicastMessage(conn, events.NewBoardUpdatedBroadcast(board, version, remainingMines))
+}
+
+// sendChunks sends the requested window of an endless board, defaulting to
+// the chunks around the origin.
+func (u *gameUsecase) sendChunks(r *room, conn *websocket.Conn, gameRequest events.ClientEvent) {
+	gameRoom := r.GameRoom
+
+	var origin minesweeper.ChunkCoord
+	if endless, ok := gameRoom.GetEndless(); ok {
+		origin = endless.GetOriginChunk()
+	}
+	window := minesweeper.ChunkWindow{
+		FromRow: origin.Row - defaultChunkRadius,
+		FromCol: origin.Col - defaultChunkRadius,
+		ToRow:   origin.Row + defaultChunkRadius,
+		ToCol:   origin.Col + defaultChunkRadius,
+	}
+	if gameRequest.Window != nil {
+		window = *gameRequest.Window
+	}
+
+	chunks, version, err := gameRoom.GetChunkWindow(window)
+	if err != nil {
+		log.Printf("error getting chunks: %v", err)
+		r.pushUnicastMessage(conn, events.NewErrorUnicast(err))
+		return
+	}
+
+	r.pushUnicastMessage(conn, events.NewChunksUnicast(chunks, version))
+}
+
+func (u *gameUsecase) broadcastChat(r *room, conn *websocket.Conn, gameRequest events.ClientEvent) {
+	log.Printf("Client is sending chat on room %v", r.ID)
+
+	playerID, ok := r.getPlayerID(conn)
+	if !ok {
+		return
+	}
+	player, ok := r.GameRoom.GetMember(playerID)
+	if !ok {
+		return
+	}
+	playerName := player.Name
+
+	log.Printf("player %s send chat", playerName)
+	broadcast := events.NewMessageBroadcast(gameRequest.Message, playerName)
+	r.pushBroadcastMessage(broadcast)
 }
 
-func (u *gameUsecase) changeSettings(conn *websocket.Conn, roomID string, gameRequest events.ClientEvent) {
-	gRoom := u.GameRooms[roomID]
+func (u *gameUsecase) broadcastPosition(r *room, conn *websocket.Conn, gameRequest events.ClientEvent) {
+	playerID, ok := r.getPlayerID(conn)
+	if !ok || r.GameRoom.IsSpectator(playerID) {
+		return
+	}
+
+	broadcast := events.NewPositionUpdateBroadcast(playerID, gameRequest.Row, gameRequest.Col)
+	r.pushBroadcastMessage(broadcast)
+}
+
+// promoteSpectator lets a spectator play from the next game on. Spectators can
+// promote themselves, the host can promote anyone.
+func (u *gameUsecase) promoteSpectator(r *room, conn *websocket.Conn, gameRequest events.ClientEvent) {
+	gameRoom := r.GameRoom
+	issuerID, _ := r.getPlayerID(conn)
+
+	targetID := gameRequest.PlayerID
+	if targetID == "" {
+		targetID = issuerID
+	}
+	if targetID != issuerID && !gameRoom.IsHost(issuerID) {
+		res := events.NewSpectatorPromotedUnicast(false, "Only host can promote other spectators")
+		r.pushUnicastMessage(conn, res)
+		return
+	}
+
+	player, err := gameRoom.PromoteSpectator(targetID)
+	if err != nil {
+		res := events.NewSpectatorPromotedUnicast(false, err.Error())
+		r.pushUnicastMessage(conn, res)
+		return
+	}
+
+	res := events.NewSpectatorPromotedUnicast(true, "Spectator has been promoted successfully")
+	r.pushUnicastMessage(conn, res)
+
+	r.pushBroadcastMessage(events.NewSpectatorPromotedBroadcast(player))
+
+	// the room had no player left to host it
+	if gameRoom.IsHost(player.PlayerID) {
+		r.pushBroadcastMessage(events.NewChangeHostBroadcast(player.PlayerID))
+	}
+}
+
+// assignTeam lets the host put a player in a team, the players cannot pick
+// their own team.
+func (u *gameUsecase) assignTeam(r *room, conn *websocket.Conn, gameRequest events.ClientEvent) {
+	issuerID, _ := r.getPlayerID(conn)
+	if !r.GameRoom.IsHost(issuerID) {
+		res := events.NewTeamAssignedUnicast(false, "Only host can assign teams")
+		r.pushUnicastMessage(conn, res)
+		return
+	}
+
+	targetID := gameRequest.PlayerID
+	if targetID == "" {
+		targetID = issuerID
+	}
+	player, err := r.GameRoom.AssignTeam(targetID, gameRequest.Team)
+	if err != nil {
+		res := events.NewTeamAssignedUnicast(false, err.Error())
+		r.pushUnicastMessage(conn, res)
+		return
+	}
+
+	res := events.NewTeamAssignedUnicast(true, "Team has been assigned successfully")
+	r.pushUnicastMessage(conn, res)
+
+	r.pushBroadcastMessage(events.NewTeamAssignedBroadcast(player))
+}
+
+func (u *gameUsecase) changeSettings(r *room, conn *websocket.Conn, gameRequest events.ClientEvent) {
+	gRoom := r.GameRoom
 	// TODO: update all the settings
-	playerID, _ := u.getPlayerID(roomID, conn)
+	playerID, _ := r.getPlayerID(conn)
 
-	if gRoom.Settings.HostID != playerID {
+	if !gRoom.IsHost(playerID) {
 		res := events.NewChangeSettingsUnicast(false, "Only host can change the settings")
-		u.pushUnicastMessage(roomID, conn, res)
+		r.pushUnicastMessage(conn, res)
 		return
 	}
 
-	if gRoom.IsStarted {
+	if gRoom.HasStarted() {
 		res := events.NewChangeSettingsUnicast(false, "Cannot change settings while the game is running")
-		u.pushUnicastMessage(roomID, conn, res)
+		r.pushUnicastMessage(conn, res)
 		return
 	}
 
 	// TODO: skip if settings is nil
 	if gameRequest.Settings == nil {
 		res := events.NewChangeSettingsUnicast(false, "Please specify a valid settings")
-		u.pushUnicastMessage(roomID, conn, res)
+		r.pushUnicastMessage(conn, res)
 		return
 	}
 
-	gRoom.Settings.Capacity = gameRequest.Settings.Capacity
-	gRoom.Settings.Difficulty = gameRequest.Settings.Difficulty
-	gRoom.Settings.CellScore = gameRequest.Settings.CellScore
-	gRoom.Settings.MineScore = gameRequest.Settings.MineScore
-	gRoom.Settings.CountColdOpen = gameRequest.Settings.CountColdOpen
+	if err := validateSettings(gameRequest.Settings); err != nil {
+		res := events.NewChangeSettingsUnicast(false, err.Error())
+		r.pushUnicastMessage(conn, res)
+		return
+	}
+
+	gRoom.UpdateSettings(*gameRequest.Settings)
 
 	res := events.NewChangeSettingsUnicast(true, "Settings has been updated successfully")
-	u.pushUnicastMessage(roomID, conn, res)
+	r.pushUnicastMessage(conn, res)
 }
 
-func (u *gameUsecase) createConnectionRoom(roomID string) {
-	u.ConnectionRooms[roomID] = make(map[*websocket.Conn]*connection)
+// validateSettings checks the settings sent by the host.
+func validateSettings(settings *minesweeper.Settings) error {
+	// a capacity of 0 means no limit, the server wide limit still applies
+	if settings.Capacity < 0 || settings.Capacity > minesweeper.MAX_CAPACITY {
+		return fmt.Errorf("capacity must be between 0 and %d", minesweeper.MAX_CAPACITY)
+	}
+	if settings.SpectatorCapacity < 0 || settings.SpectatorCapacity > minesweeper.MAX_SPECTATOR_CAPACITY {
+		return fmt.Errorf("spectator capacity must be between 0 and %d", minesweeper.MAX_SPECTATOR_CAPACITY)
+	}
+	// an empty mode or difficulty falls back to the default one
+	if settings.Mode != "" && !minesweeper.IsMode(settings.Mode) {
+		return fmt.Errorf("unknown mode %q", settings.Mode)
+	}
+	if settings.Difficulty != "" && !minesweeper.IsDifficulty(settings.Difficulty) {
+		return fmt.Errorf("unknown difficulty %q", settings.Difficulty)
+	}
+	if settings.TreasureRatio < 0 || settings.TreasureRatio > 1 {
+		return fmt.Errorf("treasure ratio must be between 0 and 1")
+	}
+	if !minesweeper.IsFlagPolicy(settings.FlagPolicy) {
+		return fmt.Errorf("unknown flag policy %q", settings.FlagPolicy)
+	}
+	if !minesweeper.IsMineHitPolicy(settings.MineHitPolicy) {
+		return fmt.Errorf("unknown mine hit policy %q", settings.MineHitPolicy)
+	}
+	if !minesweeper.IsScoringPolicy(settings.ScoringPolicy) {
+		return fmt.Errorf("unknown scoring policy %q", settings.ScoringPolicy)
+	}
+	if !minesweeper.IsFirstClickPolicy(settings.FirstClickPolicy) {
+		return fmt.Errorf("unknown first click policy %q", settings.FirstClickPolicy)
+	}
+	if !minesweeper.IsVisibility(settings.Visibility) {
+		return fmt.Errorf("unknown visibility %q", settings.Visibility)
+	}
+	for _, value := range settings.TreasureValues {
+		if value <= 0 {
+			return fmt.Errorf("treasure values must be positive")
+		}
+	}
+	return nil
 }
 
-func (u *gameUsecase) createGameRoom(roomID string, hostID string) {
-	gameRoom := minesweeper.NewGameRoom(roomID, hostID, 4)
-	u.GameRooms[roomID] = gameRoom
+func (u *gameUsecase) registerPlayer(r *room, conn *websocket.Conn, c *connection, player *minesweeper.Player, encoding events.BoardEncoding) {
+	r.GameRoom.AddPlayer(player)
+	u.bindConn(r, conn, c, player.PlayerID, encoding)
 }
 
-func (u *gameUsecase) registerPlayer(roomID string, conn *websocket.Conn, player *minesweeper.Player) {
-	u.ConnectionRooms[roomID][conn] = NewConnection(player.PlayerID)
-	gameRoom := u.GameRooms[roomID]
-	gameRoom.AddPlayer(player)
+// bindConn attaches the connection to a player of the room.
+func (u *gameUsecase) bindConn(r *room, conn *websocket.Conn, c *connection, playerID string, encoding events.BoardEncoding) {
+	c.ID = playerID
+	c.setEncoding(encoding)
+	r.addConn(conn, c)
+}
 
-	go u.writePump(conn, roomID)
+func (u *gameUsecase) unregisterPlayer(r *room, conn *websocket.Conn, playerID string) {
+	r.removeConn(conn)
+	u.leaveRoom(r, playerID)
 }
 
-func (u *gameUsecase) unregisterPlayer(roomID string, conn *websocket.Conn, playerID string) {
-	gameRoom := u.GameRooms[roomID]
+// leaveRoom removes the player from the room, appointing a new host and
+// deleting the room when necessary.
+func (u *gameUsecase) leaveRoom(r *room, playerID string) {
+	gameRoom := r.GameRoom
 	gameRoom.RemovePlayer(playerID)
-	delete(u.ConnectionRooms[roomID], conn)
+	u.rooms.releaseMember()
+
+	broadcast := events.NewGameLeftBroadcast(playerID)
+	r.pushBroadcastMessage(broadcast)
+
+	// appoint new host if necessary, the spectators left wait for one of them
+	// to be promoted
+	if gameRoom.IsHost(playerID) {
+		if newHostID := gameRoom.PickRandomHost(); newHostID != "" {
+			changeHostBroadcast := events.NewChangeHostBroadcast(newHostID)
+			r.pushBroadcastMessage(changeHostBroadcast)
+		}
+	}
 
 	// delete empty room
-	if len(u.GameRooms[roomID].Players) == 0 {
-		// TODO: cleanup cron
-		u.StopScoreCronChan[roomID] <- true
-		delete(u.GameRooms, roomID)
-		delete(u.ConnectionRooms, roomID)
+	if gameRoom.IsEmpty() {
+		u.deleteRoom(r)
+	}
+}
+
+// disconnectPlayer keeps the player of a lost connection in the room for the
+// grace period, so that it can resume its session from another connection.
+func (u *gameUsecase) disconnectPlayer(r *room, conn *websocket.Conn) {
+	playerID, ok := r.getPlayerID(conn)
+	if !ok {
+		return
 	}
+	r.removeConn(conn)
+	log.Printf("player %s disconnected from room %s", playerID, r.ID)
+
+	grace := u.sessionGrace
+	var timer *time.Timer
+	timer = time.AfterFunc(grace, func() {
+		r.post(roomEvent{task: func() {
+			// the player may have come back, and left again, since
+			if r.graceTimers[playerID] != timer {
+				return
+			}
+			delete(r.graceTimers, playerID)
+			log.Printf("session of player %s expired", playerID)
+			u.leaveRoom(r, playerID)
+		}})
+	})
+	r.stopGrace(playerID)
+	r.graceTimers[playerID] = timer
+
+	r.pushBroadcastMessage(events.NewPlayerDisconnectedBroadcast(playerID, time.Now().Add(grace)))
 }
 
-func (u *gameUsecase) updateScore(roomID string, timestamp int64) {
-	gameRoom := u.GameRooms[roomID]
+func (u *gameUsecase) resumeSession(conn *websocket.Conn, c *connection, roomID string, clientEvent events.ClientEvent) {
+	log.Printf("Client trying to resume a session on room %v", roomID)
 
-	scoreboard := map[string]int{}
-	for pID, val := range gameRoom.Players {
-		scoreboard[pID] = val.Score
+	tokenRoomID, playerID, ok := verifySession(clientEvent.SessionToken)
+	if !ok || tokenRoomID != roomID {
+		c.send(events.NewFailResumeSessionUnicast("invalid session token"))
+		return
 	}
-	u.pushBroadcastMessage(roomID, events.NewScoreUpdatedBroadcast(scoreboard, timestamp))
+
+	r, ok := u.rooms.get(roomID)
+	if ok {
+		ok = r.post(roomEvent{task: func() {
+			u.rebindPlayer(r, conn, c, playerID, clientEvent)
+		}})
+	}
+	if !ok {
+		c.send(events.NewFailResumeSessionUnicast("room does not exist"))
+	}
+}
+
+// rebindPlayer moves the player over to the new connection and sends it
+// everything it needs to pick up the game.
+func (u *gameUsecase) rebindPlayer(r *room, conn *websocket.Conn, c *connection, playerID string, clientEvent events.ClientEvent) {
+	gameRoom := r.GameRoom
+	if _, ok := gameRoom.GetMember(playerID); !ok {
+		c.send(events.NewFailResumeSessionUnicast("session expired"))
+		return
+	}
+	if _, ok := r.getPlayerID(conn); ok {
+		c.send(events.NewFailResumeSessionUnicast("already in the room"))
+		return
+	}
+
+	// the previous connection may not have noticed that it is gone yet
+	if oldConn, ok := r.findConn(playerID); ok {
+		r.removeConn(oldConn)
+	}
+	r.stopGrace(playerID)
+	u.bindConn(r, conn, c, playerID, events.ParseBoardEncoding(clientEvent.BoardEncoding))
+
+	r.pushUnicastMessage(conn, events.NewSessionResumedUnicast(playerID, gameRoom))
+	r.pushUnicastMessage(conn, events.NewScoreUpdatedBroadcast(gameRoom.Scoreboard(), time.Now().UnixNano()))
+	if gameRoom.HasStarted() {
+		if _, ok := gameRoom.GetEndless(); ok {
+			u.sendChunks(r, conn, clientEvent)
+		} else {
+			board, version := gameRoom.GetSnapshot()
+			remainingMines, _ := gameRoom.GetRemainingMines()
+			r.pushUnicastMessage(conn, events.NewBoardUpdatedBroadcast(board, version, remainingMines))
+		}
+	}
+
+	r.pushBroadcastMessage(events.NewPlayerResumedBroadcast(playerID))
+}
+
+// deleteRoom stops the room, it must be called from the room goroutine.
+func (u *gameUsecase) deleteRoom(r *room) {
+	u.rooms.remove(r)
+	r.close()
+	log.Printf("delete room %v", r.ID)
+}
+
+func (u *gameUsecase) updateScore(r *room, timestamp int64) {
+	scoreboard := r.GameRoom.Scoreboard()
+	r.pushBroadcastMessage(events.NewScoreUpdatedBroadcast(scoreboard, timestamp))
 	// here's how the new score broadcast is going to look like
 	// build a map of player id -> score, maybe include a timestamp or order id as well
 	// broadcast the message to the room
 }
 
-func (u *gameUsecase) setupScoreCron(roomID string) {
-	gameRoom := u.GameRooms[roomID]
-	gameRoom.ScoreTicker = time.NewTicker(scoreUpdateInterval)
-	stopChan := make(chan bool, 1)
+// setupScoreCron sends the scoreboard to the room periodically, replacing the
+// cron of the previous game if any.
+func (u *gameUsecase) setupScoreCron(r *room) {
+	r.stopCron()
+	ticker := time.NewTicker(scoreUpdateInterval)
+	r.GameRoom.SetScoreTicker(ticker)
+	stopChan := make(chan struct{})
+	r.stopScoreCron = stopChan
 
-	u.StopScoreCronChan[roomID] = stopChan
-	go func(roomID string) {
+	go func() {
 		for {
 			select {
-			case t := <-gameRoom.ScoreTicker.C:
-				u.updateScore(roomID, t.UnixNano())
+			case t := <-ticker.C:
+				r.post(roomEvent{task: func() {
+					u.updateScore(r, t.UnixNano())
+				}})
 			case <-stopChan:
 				return
 			}
 		}
-	}(roomID)
+	}()
 }
 
-func (u *gameUsecase) writePump(conn *websocket.Conn, roomID string) {
-	c := u.ConnectionRooms[roomID][conn]
-	defer func() {
-		conn.Close()
-	}()
+// setupRoomTicker runs the timed events of the game on the room goroutine
+// until the game ends.
+func (u *gameUsecase) setupRoomTicker(r *room, interval time.Duration, tick func()) {
+	ticker := time.NewTicker(interval)
+	done := r.GameRoom.SetRoomTicker(ticker)
 
-	for {
-		message := <-c.Queue
-		err := conn.WriteJSON(message)
-		if err != nil {
-			log.Println("failed to write json:", err.Error())
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				r.post(roomEvent{task: tick})
+			case <-done:
+				return
+			}
 		}
+	}()
+}
 
-		if _, ok := message.(*events.GameLeftUnicast); ok {
-			u.unregisterPlayer(roomID, conn, c.ID)
-			return
-		}
+// growBoard grows the expanding board with the given method and sends the new
+// board to everyone.
+func (u *gameUsecase) growBoard(r *room, grow func() (int, error)) error {
+	gameRoom := r.GameRoom
+	mines, err := grow()
+	if err != nil {
+		log.Printf("failed to grow the board: %v", err)
+		return err
+	}
+
+	board, version := gameRoom.GetSnapshot()
+	remainingMines, _ := gameRoom.GetRemainingMines()
+	res := events.NewBoardUpdatedBroadcast(board, version, remainingMines)
+	r.pushBroadcastMessage(res)
+
+	notifContent := fmt.Sprintf("the board grew to %dx%d with %d new mines", res.Rows, res.Cols, mines)
+	notification := events.NewNotificationBroadcast(notifContent)
+	r.pushBroadcastMessage(notification)
+
+	return nil
+}
+
+func (u *gameUsecase) spawnMines(r *room) {
+	gameRoom := r.GameRoom
+	planted, diff, err := gameRoom.SpawnMines()
+	if err != nil || planted == 0 {
+		return
+	}
+
+	r.pushBroadcastMessage(events.NewBoardDiffBroadcast(diff))
+	notification := events.NewNotificationBroadcast(fmt.Sprintf("%d new mines were planted", planted))
+	r.pushBroadcastMessage(notification)
+
+	// the new mines may have taken the last closed cells
+	if gameRoom.IsCleared() && gameRoom.End() == nil {
+		u.updateScore(r, time.Now().Unix())
+
+		notification := events.NewNotificationBroadcast("mines are cleared, the last cells were taken by the mines!")
+		r.pushBroadcastMessage(notification)
+
+		res := events.NewGameClearedBroadcast(gameRoom.GetCellStringBare(), gameRoom.GetPlayers())
+		r.pushBroadcastMessage(res)
+		u.sendTreasureSummary(r)
 	}
 }
 
-func (u *gameUsecase) RunSwitch() {
+// writePump writes the messages queued for the client and pings it until the
+// connection is closed.
+func (u *gameUsecase) writePump(conn *websocket.Conn, c *connection) {
+	ticker := time.NewTicker(u.pingInterval)
+	defer func() {
+		ticker.Stop()
+		conn.Close()
+		u.pumps.Done()
+	}()
+
 	for {
-		event := <-u.SwitchQueue
-		conRoom := u.ConnectionRooms[event.RoomID]
-		if conRoom == nil {
-			continue
-		}
+		select {
+		case message, ok := <-c.Queue:
+			conn.SetWriteDeadline(time.Now().Add(u.writeWait))
+			if !ok {
+				closeMessage := c.closeMessage
+				if closeMessage == nil {
+					closeMessage = websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+				}
+				conn.WriteMessage(websocket.CloseMessage, closeMessage)
+				return
+			}
 
-		if event.EventType == events.UnicastSocketEvent {
-			pConn := conRoom[event.Conn]
-			if pConn == nil {
-				log.Println("nil conn for unicast")
-				continue
+			if err := conn.WriteJSON(message); err != nil {
+				log.Println("failed to write json:", err.Error())
+				discardQueue(conn, c)
+				return
 			}
-			pConn.Queue <- event.Message
-		} else {
-			for _, con := range conRoom {
-				con.Queue <- event.Message
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(u.writeWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				log.Println("failed to ping:", err.Error())
+				discardQueue(conn, c)
+				return
 			}
 		}
 	}
 }
 
-func (u *gameUsecase) pushUnicastMessage(roomID string, conn *websocket.Conn, message interface{}) {
-	u.SwitchQueue <- events.NewUnicastEvent(roomID, conn, message)
+// discardQueue gives up on a broken connection. Closing it fails its read
+// loop, which takes the client out of its room and closes the queue, until
+// then the messages are dropped so that no sender blocks.
+func discardQueue(conn *websocket.Conn, c *connection) {
+	conn.Close()
+	for range c.Queue {
+	}
 }
 
-func (u *gameUsecase) pushBroadcastMessage(roomID string, message interface{}) {
-	u.SwitchQueue <- events.NewBroadcastEvent(roomID, message)
+// keepAlive bounds the messages read from the client and fails the reads once
+// the client stops answering the pings of its write pump, which runs from the
+// moment it connects.
+func (u *gameUsecase) keepAlive(conn *websocket.Conn) {
+	conn.SetReadLimit(u.maxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(u.pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(u.pongWait))
+	})
 }
@@ -0,0 +1,892 @@
+package usecases_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aryuuu/mines-party-server/configs"
+	"github.com/aryuuu/mines-party-server/events"
+	"github.com/aryuuu/mines-party-server/minesweeper"
+	"github.com/aryuuu/mines-party-server/usecases"
+	"github.com/gorilla/websocket"
+)
+
+func newTestServer(t *testing.T, grace time.Duration, playerCapacity int) *httptest.Server {
+	t.Helper()
+
+	configs.Constant.Capacity = 100
+	configs.Constant.PlayerCapacity = playerCapacity
+	configs.Constant.SessionGrace = grace
+	return serve(t, usecases.NewGameUsecase())
+}
+
+func serve(t *testing.T, uc usecases.GameUsecase) *httptest.Server {
+	t.Helper()
+
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/create" {
+			code, err := uc.ReserveRoomCode(r.URL.Query().Get("ip"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprint(w, code)
+			return
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		if r.URL.Path == "/matchmaking" {
+			uc.Matchmake(conn)
+			return
+		}
+		// the tests pick the client IP in the query
+		uc.Connect(conn, strings.TrimPrefix(r.URL.Path, "/"), r.URL.Query().Get("ip"))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newRoomID reserves the code of a new room, as the clients do before creating
+// one.
+func newRoomID(t *testing.T, server *httptest.Server) string {
+	t.Helper()
+
+	res, err := http.Get(server.URL + "/create")
+	if err != nil {
+		t.Fatalf("failed to reserve a room code: %v", err)
+	}
+	defer res.Body.Close()
+	code, err := io.ReadAll(res.Body)
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("failed to reserve a room code: %s %v", code, err)
+	}
+	return string(code)
+}
+
+func dial(t *testing.T, server *httptest.Server, roomID string) *websocket.Conn {
+	t.Helper()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/" + roomID
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readEvent reads messages until one of the given type comes up.
+func readEvent(conn *websocket.Conn, eventType events.EventType) (map[string]interface{}, error) {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		message := map[string]interface{}{}
+		if err := conn.ReadJSON(&message); err != nil {
+			return nil, err
+		}
+		if message["event_type"] == string(eventType) {
+			return message, nil
+		}
+	}
+}
+
+func TestConcurrentRooms(t *testing.T) {
+	server := newTestServer(t, time.Minute, 0)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	roomIDs := []string{}
+	for i := 0; i < 8; i++ {
+		roomID := newRoomID(t, server)
+		roomIDs = append(roomIDs, roomID)
+		host := dial(t, server, roomID)
+		guests := []*websocket.Conn{}
+		// the host and the guests fill the room
+		for j := 0; j < 3; j++ {
+			guests = append(guests, dial(t, server, roomID))
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			host.WriteJSON(events.ClientEvent{EventType: events.CreateRoomEvent, ClientName: "host"})
+			res, err := readEvent(host, events.CreateRoomEvent)
+			if err != nil || res["success"] != true {
+				errs <- fmt.Errorf("room %s was not created: %v %v", roomID, res, err)
+				return
+			}
+
+			var guestsWg sync.WaitGroup
+			for j, guest := range guests {
+				guestsWg.Add(1)
+				go func(j int, guest *websocket.Conn) {
+					defer guestsWg.Done()
+
+					name := fmt.Sprintf("guest%d", j)
+					guest.WriteJSON(events.ClientEvent{EventType: events.JoinRoomEvent, ClientName: name})
+					res, err := readEvent(guest, events.JoinRoomEvent)
+					if err != nil || res["id_player"] == "" {
+						errs <- fmt.Errorf("%s did not join room %s: %v %v", name, roomID, res, err)
+						return
+					}
+
+					guest.WriteJSON(events.ClientEvent{EventType: events.ChatEvent, Message: "hi"})
+					guest.WriteJSON(events.ClientEvent{EventType: events.PositionUpdatedEvent, Row: 1, Col: 2})
+					guest.WriteJSON(events.ClientEvent{EventType: events.GameLeftEvent})
+					if _, err := readEvent(guest, events.GameLeftEvent); err != nil {
+						errs <- fmt.Errorf("%s did not leave room %s: %v", name, roomID, err)
+					}
+				}(j, guest)
+			}
+			guestsWg.Wait()
+
+			host.WriteJSON(events.ClientEvent{EventType: events.StartGameEvent})
+			if _, err := readEvent(host, events.StartGameEvent); err != nil {
+				errs <- fmt.Errorf("game of room %s did not start: %v", roomID, err)
+			}
+			host.WriteJSON(events.ClientEvent{EventType: events.OpenCellEvent, Row: 0, Col: 0})
+			host.WriteJSON(events.ClientEvent{EventType: events.GameLeftEvent})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+
+	// the rooms are deleted once empty, until then the name of the host is
+	// taken
+	conn := dial(t, server, roomIDs[0])
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn.WriteJSON(events.ClientEvent{EventType: events.JoinRoomEvent, ClientName: "host"})
+		res, err := readEvent(conn, events.JoinRoomEvent)
+		if err != nil {
+			t.Fatalf("failed to read: %v", err)
+		}
+		if res["reason"] == string(events.ErrorCodeRoomNotFound) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("room %s was not deleted", roomIDs[0])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestResumeSession(t *testing.T) {
+	server := newTestServer(t, time.Minute, 0)
+
+	roomID := newRoomID(t, server)
+	host := dial(t, server, roomID)
+	host.WriteJSON(events.ClientEvent{EventType: events.CreateRoomEvent, ClientName: "host"})
+	created, err := readEvent(host, events.CreateRoomEvent)
+	if err != nil {
+		t.Fatalf("failed to create the room: %v", err)
+	}
+	token, _ := created["session_token"].(string)
+	if token == "" {
+		t.Fatalf("expected a session token, got %v", created)
+	}
+
+	guest := dial(t, server, roomID)
+	guest.WriteJSON(events.ClientEvent{EventType: events.JoinRoomEvent, ClientName: "guest"})
+	joined, err := readEvent(guest, events.JoinRoomEvent)
+	if err != nil || joined["session_token"] == "" {
+		t.Fatalf("expected a session token, got %v %v", joined, err)
+	}
+
+	// drop the connection without a close handshake
+	host.UnderlyingConn().Close()
+	disconnected, err := readEvent(guest, events.PlayerDisconnectedEvent)
+	if err != nil {
+		t.Fatalf("expected the host to be disconnected: %v", err)
+	}
+
+	forged := dial(t, server, roomID)
+	forged.WriteJSON(events.ClientEvent{EventType: events.ResumeSessionEvent, SessionToken: token + "x"})
+	if res, err := readEvent(forged, events.ResumeSessionEvent); err != nil || res["success"] != false {
+		t.Errorf("expected a forged token to be refused, got %v %v", res, err)
+	}
+
+	resumed := dial(t, server, roomID)
+	resumed.WriteJSON(events.ClientEvent{EventType: events.ResumeSessionEvent, SessionToken: token})
+	res, err := readEvent(resumed, events.ResumeSessionEvent)
+	if err != nil || res["success"] != true || res["id_player"] != disconnected["id_player"] {
+		t.Fatalf("expected the session to resume, got %v %v", res, err)
+	}
+	if _, err := readEvent(guest, events.PlayerResumedEvent); err != nil {
+		t.Errorf("expected the host to be back: %v", err)
+	}
+
+	// the resumed player is still the host
+	resumed.WriteJSON(events.ClientEvent{EventType: events.StartGameEvent})
+	if res, err := readEvent(resumed, events.StartGameEvent); err != nil || res["success"] != true {
+		t.Errorf("expected the game to start, got %v %v", res, err)
+	}
+}
+
+func TestSessionGraceExpires(t *testing.T) {
+	server := newTestServer(t, 50*time.Millisecond, 0)
+
+	roomID := newRoomID(t, server)
+	host := dial(t, server, roomID)
+	host.WriteJSON(events.ClientEvent{EventType: events.CreateRoomEvent, ClientName: "host"})
+	if _, err := readEvent(host, events.CreateRoomEvent); err != nil {
+		t.Fatalf("failed to create the room: %v", err)
+	}
+
+	guest := dial(t, server, roomID)
+	guest.WriteJSON(events.ClientEvent{EventType: events.JoinRoomEvent, ClientName: "guest"})
+	joined, err := readEvent(guest, events.JoinRoomEvent)
+	if err != nil {
+		t.Fatalf("failed to join the room: %v", err)
+	}
+	token, _ := joined["session_token"].(string)
+
+	guest.UnderlyingConn().Close()
+	left, err := readEvent(host, events.LeaveRoomBroadcastEvent)
+	if err != nil || left["id_player"] != joined["id_player"] {
+		t.Fatalf("expected the guest to leave once the grace period is over, got %v %v", left, err)
+	}
+
+	resumed := dial(t, server, roomID)
+	resumed.WriteJSON(events.ClientEvent{EventType: events.ResumeSessionEvent, SessionToken: token})
+	if res, err := readEvent(resumed, events.ResumeSessionEvent); err != nil || res["success"] != false {
+		t.Errorf("expected the expired session to be refused, got %v %v", res, err)
+	}
+}
+
+func TestSpectator(t *testing.T) {
+	server := newTestServer(t, time.Minute, 0)
+
+	roomID := newRoomID(t, server)
+	host := dial(t, server, roomID)
+	host.WriteJSON(events.ClientEvent{EventType: events.CreateRoomEvent, ClientName: "host"})
+	if _, err := readEvent(host, events.CreateRoomEvent); err != nil {
+		t.Fatalf("failed to create the room: %v", err)
+	}
+
+	screen := dial(t, server, roomID)
+	screen.WriteJSON(events.ClientEvent{EventType: events.JoinRoomEvent, ClientName: "screen", Spectate: true})
+	if _, err := readEvent(screen, events.JoinRoomEvent); err != nil {
+		t.Fatalf("failed to join the room: %v", err)
+	}
+	if res, err := readEvent(host, events.JoinRoomBroadcastEvent); err != nil || res["spectator"] != true {
+		t.Errorf("expected a spectator to join, got %v %v", res, err)
+	}
+
+	host.WriteJSON(events.ClientEvent{EventType: events.StartGameEvent})
+	if _, err := readEvent(screen, events.StartGameEvent); err != nil {
+		t.Fatalf("expected the spectator to watch the game start: %v", err)
+	}
+
+	screen.WriteJSON(events.ClientEvent{EventType: events.OpenCellEvent, Row: 0, Col: 0})
+	res, err := readEvent(screen, events.ErrorEvent)
+	if err != nil || res["code"] != string(events.ErrorCodeSpectator) {
+		t.Errorf("expected the spectator not to play, got %v %v", res, err)
+	}
+
+	screen.WriteJSON(events.ClientEvent{EventType: events.PromoteSpectatorEvent})
+	if res, err := readEvent(screen, events.PromoteSpectatorEvent); err != nil || res["success"] != false {
+		t.Errorf("expected no promotion during a game, got %v %v", res, err)
+	}
+}
+
+func TestSpectatorsWithoutHost(t *testing.T) {
+	server := newTestServer(t, time.Minute, 0)
+
+	roomID := newRoomID(t, server)
+	host := dial(t, server, roomID)
+	host.WriteJSON(events.ClientEvent{EventType: events.CreateRoomEvent, ClientName: "host"})
+	if _, err := readEvent(host, events.CreateRoomEvent); err != nil {
+		t.Fatalf("failed to create the room: %v", err)
+	}
+	screen := dial(t, server, roomID)
+	screen.WriteJSON(events.ClientEvent{EventType: events.JoinRoomEvent, ClientName: "screen", Spectate: true})
+	res, err := readEvent(screen, events.JoinRoomEvent)
+	if err != nil {
+		t.Fatalf("failed to join the room: %v", err)
+	}
+	screenID := res["id_player"]
+
+	// nobody takes over until the spectator is promoted
+	host.WriteJSON(events.ClientEvent{EventType: events.GameLeftEvent})
+	if _, err := readEvent(screen, events.LeaveRoomBroadcastEvent); err != nil {
+		t.Fatalf("expected the host to leave: %v", err)
+	}
+	screen.WriteJSON(events.ClientEvent{EventType: events.PromoteSpectatorEvent})
+	if res, err := readEvent(screen, events.HostChangedEvent); err != nil || res["id_player"] != screenID {
+		t.Errorf("expected the promoted spectator to be the first new host, got %v %v", res, err)
+	}
+}
+
+func TestJoinFailures(t *testing.T) {
+	server := newTestServer(t, time.Minute, 3)
+
+	join := func(roomID, name string, spectate bool) map[string]interface{} {
+		t.Helper()
+
+		conn := dial(t, server, roomID)
+		conn.WriteJSON(events.ClientEvent{EventType: events.JoinRoomEvent, ClientName: name, Spectate: spectate})
+		res, err := readEvent(conn, events.JoinRoomEvent)
+		if err != nil {
+			t.Fatalf("failed to read: %v", err)
+		}
+		return res
+	}
+
+	if res := join("nowhere", "guest", false); res["success"] != false || res["reason"] != string(events.ErrorCodeRoomNotFound) {
+		t.Errorf("expected room_not_found, got %v", res)
+	}
+
+	roomID := newRoomID(t, server)
+	host := dial(t, server, roomID)
+	host.WriteJSON(events.ClientEvent{EventType: events.CreateRoomEvent, ClientName: "host"})
+	if _, err := readEvent(host, events.CreateRoomEvent); err != nil {
+		t.Fatalf("failed to create the room: %v", err)
+	}
+
+	if res := join(roomID, "host", false); res["reason"] != string(events.ErrorCodeNameTaken) {
+		t.Errorf("expected name_taken, got %v", res)
+	}
+
+	host.WriteJSON(events.ClientEvent{EventType: events.StartGameEvent})
+	if _, err := readEvent(host, events.StartGameEvent); err != nil {
+		t.Fatalf("failed to start the game: %v", err)
+	}
+	if res := join(roomID, "late", false); res["reason"] != string(events.ErrorCodeGameInProgress) {
+		t.Errorf("expected game_in_progress, got %v", res)
+	}
+
+	// the host and two spectators fill the server
+	for _, name := range []string{"screen", "boss"} {
+		if res := join(roomID, name, true); res["success"] != true {
+			t.Fatalf("expected %s to spectate, got %v", name, res)
+		}
+	}
+	if res := join(roomID, "intern", true); res["reason"] != string(events.ErrorCodeServerFull) {
+		t.Errorf("expected server_full, got %v", res)
+	}
+}
+
+func TestProtectedRooms(t *testing.T) {
+	server := newTestServer(t, time.Minute, 0)
+
+	join := func(roomID string, clientEvent events.ClientEvent) map[string]interface{} {
+		t.Helper()
+
+		conn := dial(t, server, roomID)
+		clientEvent.EventType = events.JoinRoomEvent
+		conn.WriteJSON(clientEvent)
+		res, err := readEvent(conn, events.JoinRoomEvent)
+		if err != nil {
+			t.Fatalf("failed to read: %v", err)
+		}
+		return res
+	}
+
+	roomID := newRoomID(t, server)
+	host := dial(t, server, roomID)
+	host.WriteJSON(events.ClientEvent{EventType: events.CreateRoomEvent, ClientName: "host", Password: "pizza"})
+	created, err := readEvent(host, events.CreateRoomEvent)
+	if err != nil {
+		t.Fatalf("failed to create the room: %v", err)
+	}
+	settings := created["game_room"].(map[string]interface{})["settings"].(map[string]interface{})
+	if _, ok := settings["password"]; ok || settings["has_password"] != true {
+		t.Errorf("expected the password to be hidden, got %v", settings)
+	}
+
+	if res := join(roomID, events.ClientEvent{ClientName: "stranger"}); res["reason"] != string(events.ErrorCodeWrongPassword) {
+		t.Errorf("expected wrong_password, got %v", res)
+	}
+	if res := join(roomID, events.ClientEvent{ClientName: "alice", Password: "pizza"}); res["success"] != true {
+		t.Errorf("expected the password to let alice in, got %v", res)
+	}
+
+	host.WriteJSON(events.ClientEvent{EventType: events.ChangeSettingsEvent, Settings: &minesweeper.Settings{Capacity: 4, SpectatorCapacity: 8, InviteOnly: true}})
+	if res, err := readEvent(host, events.SettingsUpdatedEvent); err != nil || res["success"] != true {
+		t.Fatalf("failed to update the settings: %v %v", res, err)
+	}
+	if res := join(roomID, events.ClientEvent{ClientName: "bob", Password: "pizza"}); res["reason"] != string(events.ErrorCodeInviteRequired) {
+		t.Errorf("expected invite_required, got %v", res)
+	}
+
+	host.WriteJSON(events.ClientEvent{EventType: events.CreateInviteEvent})
+	invite, err := readEvent(host, events.CreateInviteEvent)
+	if err != nil || invite["success"] != true {
+		t.Fatalf("failed to create an invite: %v %v", invite, err)
+	}
+	token, _ := invite["invite_token"].(string)
+	if res := join(roomID, events.ClientEvent{ClientName: "bob", InviteToken: token}); res["success"] != true {
+		t.Errorf("expected the invite to let bob in, got %v", res)
+	}
+
+	// the failed attempts of an IP are limited, whatever the room
+	for i := 0; i < 5; i++ {
+		join(roomID+"?ip=10.0.0.1", events.ClientEvent{ClientName: "mallory", Password: "guess"})
+	}
+	if res := join(roomID+"?ip=10.0.0.1", events.ClientEvent{ClientName: "mallory", InviteToken: token}); res["reason"] != string(events.ErrorCodeRateLimited) {
+		t.Errorf("expected rate_limited, got %v", res)
+	}
+	if res := join(roomID+"?ip=10.0.0.2", events.ClientEvent{ClientName: "carol", InviteToken: token}); res["success"] != true {
+		t.Errorf("expected another IP to get in, got %v", res)
+	}
+}
+
+func TestTeams(t *testing.T) {
+	server := newTestServer(t, time.Minute, 0)
+
+	roomID := newRoomID(t, server)
+	host := dial(t, server, roomID)
+	host.WriteJSON(events.ClientEvent{EventType: events.CreateRoomEvent, ClientName: "host", Team: "red"})
+	res, err := readEvent(host, events.CreateRoomEvent)
+	if err != nil {
+		t.Fatalf("failed to create the room: %v", err)
+	}
+	hostID := res["game_room"].(map[string]interface{})["settings"].(map[string]interface{})["id_host"].(string)
+	host.WriteJSON(events.ClientEvent{EventType: events.ChangeSettingsEvent, Settings: &minesweeper.Settings{Capacity: 4, FlagPolicy: minesweeper.FLAG_POLICY_TEAM}})
+	if res, err := readEvent(host, events.SettingsUpdatedEvent); err != nil || res["success"] != true {
+		t.Fatalf("failed to update the settings: %v %v", res, err)
+	}
+	host.WriteJSON(events.ClientEvent{EventType: events.AssignTeamEvent, PlayerID: hostID, Team: "red"})
+	if res, err := readEvent(host, events.AssignTeamEvent); err != nil || res["success"] != true {
+		t.Fatalf("failed to assign the team of the host: %v %v", res, err)
+	}
+
+	// the teams the players claim for themselves are ignored
+	griefer := dial(t, server, roomID)
+	griefer.WriteJSON(events.ClientEvent{EventType: events.JoinRoomEvent, ClientName: "griefer", Team: "red"})
+	res, err = readEvent(griefer, events.JoinRoomEvent)
+	if err != nil || res["success"] != true {
+		t.Fatalf("failed to join the room: %v %v", res, err)
+	}
+	grieferID := res["id_player"].(string)
+	griefer.WriteJSON(events.ClientEvent{EventType: events.AssignTeamEvent, PlayerID: grieferID, Team: "red"})
+	if res, err := readEvent(griefer, events.AssignTeamEvent); err != nil || res["success"] != false {
+		t.Errorf("expected only the host to assign teams, got %v %v", res, err)
+	}
+
+	host.WriteJSON(events.ClientEvent{EventType: events.StartGameEvent})
+	if _, err := readEvent(host, events.StartGameEvent); err != nil {
+		t.Fatalf("failed to start the game: %v", err)
+	}
+	host.WriteJSON(events.ClientEvent{EventType: events.FlagCellEvent, Row: 0, Col: 0})
+	if _, err := readEvent(griefer, events.BoardDiffEvent); err != nil {
+		t.Fatalf("failed to flag the cell: %v", err)
+	}
+	griefer.WriteJSON(events.ClientEvent{EventType: events.FlagCellEvent, Row: 0, Col: 0})
+	if res, err := readEvent(griefer, events.ErrorEvent); err != nil || res["code"] != string(events.ErrorCodeFlagNotOwned) {
+		t.Errorf("expected flag_not_owned, got %v %v", res, err)
+	}
+}
+
+func TestInvalidSettings(t *testing.T) {
+	server := newTestServer(t, time.Minute, 0)
+
+	roomID := newRoomID(t, server)
+	host := dial(t, server, roomID)
+	host.WriteJSON(events.ClientEvent{EventType: events.CreateRoomEvent, ClientName: "host"})
+	if _, err := readEvent(host, events.CreateRoomEvent); err != nil {
+		t.Fatalf("failed to create the room: %v", err)
+	}
+
+	for _, settings := range []minesweeper.Settings{
+		{Capacity: -1, SpectatorCapacity: 8},
+		{Capacity: 4, SpectatorCapacity: -1},
+		{Capacity: minesweeper.MAX_CAPACITY + 1, SpectatorCapacity: 8},
+		{Capacity: 4, SpectatorCapacity: minesweeper.MAX_SPECTATOR_CAPACITY + 1},
+		{Capacity: 4, SpectatorCapacity: 8, TreasureRatio: 2},
+		{Capacity: 4, SpectatorCapacity: 8, TreasureRatio: -0.5},
+		{Capacity: 4, SpectatorCapacity: 8, TreasureRatio: 0.1, TreasureValues: []int{10, 0}},
+		{Capacity: 4, SpectatorCapacity: 8, FlagPolicy: "whatever"},
+		{Capacity: 4, SpectatorCapacity: 8, Mode: "whatever"},
+		{Capacity: 4, SpectatorCapacity: 8, Difficulty: "whatever"},
+		{Capacity: 4, SpectatorCapacity: 8, MineHitPolicy: "whatever"},
+		{Capacity: 4, SpectatorCapacity: 8, ScoringPolicy: "whatever"},
+		{Capacity: 4, SpectatorCapacity: 8, FirstClickPolicy: "whatever"},
+		{Capacity: 4, SpectatorCapacity: 8, Visibility: "whatever"},
+	} {
+		settings := settings
+		host.WriteJSON(events.ClientEvent{EventType: events.ChangeSettingsEvent, Settings: &settings})
+		if res, err := readEvent(host, events.SettingsUpdatedEvent); err != nil || res["success"] != false {
+			t.Errorf("expected %+v to be rejected, got %v %v", settings, res, err)
+		}
+	}
+
+	// older clients leave the spectator capacity out, 0 means no limit
+	host.WriteJSON(events.ClientEvent{EventType: events.ChangeSettingsEvent, Settings: &minesweeper.Settings{Capacity: 4}})
+	if res, err := readEvent(host, events.SettingsUpdatedEvent); err != nil || res["success"] != true {
+		t.Errorf("expected the settings without a spectator capacity to be accepted, got %v %v", res, err)
+	}
+}
+
+func TestOpenCellErrors(t *testing.T) {
+	server := newTestServer(t, time.Minute, 0)
+
+	roomID := newRoomID(t, server)
+	host := dial(t, server, roomID)
+	host.WriteJSON(events.ClientEvent{EventType: events.CreateRoomEvent, ClientName: "host"})
+	if _, err := readEvent(host, events.CreateRoomEvent); err != nil {
+		t.Fatalf("failed to create the room: %v", err)
+	}
+	host.WriteJSON(events.ClientEvent{EventType: events.StartGameEvent})
+	if _, err := readEvent(host, events.StartGameEvent); err != nil {
+		t.Fatalf("failed to start the game: %v", err)
+	}
+
+	host.WriteJSON(events.ClientEvent{EventType: events.OpenCellEvent, Row: -1, Col: -1})
+	if res, err := readEvent(host, events.ErrorEvent); err != nil || res["code"] != string(events.ErrorCodeOutOfBounds) {
+		t.Errorf("expected out_of_bounds, got %v %v", res, err)
+	}
+}
+
+func TestLobby(t *testing.T) {
+	configs.Constant.Capacity = 100
+	configs.Constant.PlayerCapacity = 0
+	uc := usecases.NewGameUsecase()
+	server := serve(t, uc)
+
+	updates, unsubscribe := uc.SubscribeLobby()
+	defer unsubscribe()
+	nextUpdate := func() *events.LobbyUpdate {
+		t.Helper()
+
+		select {
+		case update := <-updates:
+			return update
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected a lobby update")
+			return nil
+		}
+	}
+
+	roomID := newRoomID(t, server)
+	host := dial(t, server, roomID)
+	host.WriteJSON(events.ClientEvent{EventType: events.CreateRoomEvent, ClientName: "host"})
+	if _, err := readEvent(host, events.CreateRoomEvent); err != nil {
+		t.Fatalf("failed to create the room: %v", err)
+	}
+	if rooms := uc.ListRooms(); len(rooms) != 0 {
+		t.Errorf("expected private rooms to be unlisted, got %v", rooms)
+	}
+
+	host.WriteJSON(events.ClientEvent{EventType: events.ChangeSettingsEvent, Settings: &minesweeper.Settings{Capacity: 4, SpectatorCapacity: 8, Visibility: minesweeper.VISIBILITY_PUBLIC}})
+	update := nextUpdate()
+	if update.EventType != events.LobbyRoomUpdatedEvent || update.Room.HostName != "host" || update.Room.PlayerCount != 1 {
+		t.Errorf("expected the room to be listed, got %+v", update.Room)
+	}
+	if rooms := uc.ListRooms(); len(rooms) != 1 || rooms[0].RoomID != roomID || rooms[0].Capacity != 4 {
+		t.Errorf("expected the public room to be listed, got %v", rooms)
+	}
+
+	guest := dial(t, server, roomID)
+	guest.WriteJSON(events.ClientEvent{EventType: events.JoinRoomEvent, ClientName: "guest"})
+	if update := nextUpdate(); update.Room == nil || update.Room.PlayerCount != 2 {
+		t.Errorf("expected the new player to be counted, got %+v", update)
+	}
+
+	host.WriteJSON(events.ClientEvent{EventType: events.ChangeSettingsEvent, Settings: &minesweeper.Settings{Capacity: 4, SpectatorCapacity: 8, Visibility: minesweeper.VISIBILITY_PUBLIC, InviteOnly: true}})
+	if update := nextUpdate(); update.EventType != events.LobbyRoomRemovedEvent || update.RoomID != roomID {
+		t.Errorf("expected invite only rooms to be unlisted, got %+v", update)
+	}
+}
+
+func TestMatchmaking(t *testing.T) {
+	configs.Constant.Capacity = 100
+	configs.Constant.PlayerCapacity = 0
+	configs.Constant.MatchTimeout = 2 * time.Second
+	server := serve(t, usecases.NewGameUsecase())
+
+	queue := func(request events.MatchRequest) *websocket.Conn {
+		t.Helper()
+
+		conn := dial(t, server, "matchmaking")
+		request.EventType = events.QueueMatchEvent
+		conn.WriteJSON(request)
+		return conn
+	}
+
+	invalid := queue(events.MatchRequest{Difficulty: "impossible"})
+	if res, err := readEvent(invalid, events.MatchFailedEvent); err != nil || res["reason"] != string(events.ErrorCodeInvalidPreferences) {
+		t.Errorf("expected invalid_preferences, got %v %v", res, err)
+	}
+
+	alice := queue(events.MatchRequest{Difficulty: "easy", Rating: 1000})
+	if res, err := readEvent(alice, events.QueuePositionEvent); err != nil || res["position"] != float64(1) {
+		t.Errorf("expected alice to be first in the queue, got %v %v", res, err)
+	}
+	// bob is too far off in rating, carol wants another game
+	bob := queue(events.MatchRequest{Difficulty: "easy", Rating: 2000})
+	carol := queue(events.MatchRequest{Difficulty: "hard"})
+	dave := queue(events.MatchRequest{Difficulty: "easy", Rating: 1050})
+
+	var roomID, invite string
+	for _, conn := range []*websocket.Conn{alice, dave} {
+		res, err := readEvent(conn, events.MatchFoundEvent)
+		if err != nil {
+			t.Fatalf("expected a match: %v", err)
+		}
+		if roomID != "" && res["id_room"] != roomID {
+			t.Errorf("expected the same room, got %v and %v", roomID, res["id_room"])
+		}
+		roomID, invite = res["id_room"].(string), res["invite_token"].(string)
+	}
+
+	// nobody hosts the match before its first player joins
+	intruder := dial(t, server, roomID)
+	intruder.WriteJSON(events.ClientEvent{EventType: events.ChangeSettingsEvent, Settings: &minesweeper.Settings{Capacity: 2}})
+	intruder.WriteJSON(events.ClientEvent{EventType: events.JoinRoomEvent, ClientName: "mallory"})
+	if res, err := readEvent(intruder, events.JoinRoomEvent); err != nil || res["reason"] != string(events.ErrorCodeInviteRequired) {
+		t.Errorf("expected the match to stay invite only, got %v %v", res, err)
+	}
+
+	player := dial(t, server, roomID)
+	player.WriteJSON(events.ClientEvent{EventType: events.JoinRoomEvent, ClientName: "alice", InviteToken: invite})
+	res, err := readEvent(player, events.JoinRoomEvent)
+	if err != nil || res["success"] != true {
+		t.Fatalf("expected alice to join the match, got %v %v", res, err)
+	}
+	settings := res["game_room"].(map[string]interface{})["settings"].(map[string]interface{})
+	if settings["id_host"] != res["id_player"] || settings["difficulty"] != "easy" || settings["capacity"] != float64(2) {
+		t.Errorf("expected alice to host an easy game for two, got %v", settings)
+	}
+
+	stranger := dial(t, server, roomID)
+	stranger.WriteJSON(events.ClientEvent{EventType: events.JoinRoomEvent, ClientName: "eve"})
+	if res, err := readEvent(stranger, events.JoinRoomEvent); err != nil || res["reason"] != string(events.ErrorCodeInviteRequired) {
+		t.Errorf("expected the match to be invite only, got %v %v", res, err)
+	}
+
+	for _, conn := range []*websocket.Conn{bob, carol} {
+		if res, err := readEvent(conn, events.MatchFailedEvent); err != nil || res["reason"] != string(events.ErrorCodeMatchTimeout) {
+			t.Errorf("expected match_timeout, got %v %v", res, err)
+		}
+	}
+}
+
+func TestRoomCodes(t *testing.T) {
+	defaults := *configs.Constant
+	t.Cleanup(func() { *configs.Constant = defaults })
+	configs.Constant.Capacity = 100
+	configs.Constant.RoomCodeAlphabet = "a"
+	configs.Constant.RoomCodeLength = 2
+	configs.Constant.RoomCodeWords = false
+	configs.Constant.RoomCodeTTL = 200 * time.Millisecond
+	uc := usecases.NewGameUsecase()
+	server := serve(t, uc)
+
+	// the alphabet only makes a single code
+	code, err := uc.ReserveRoomCode("")
+	if err != nil || code != "aa" {
+		t.Fatalf("expected to reserve aa, got %q %v", code, err)
+	}
+	if code, err := uc.ReserveRoomCode(""); err == nil {
+		t.Errorf("expected the reserved code not to be handed out again, got %q", code)
+	}
+
+	// the reservation is released once it expires
+	time.Sleep(300 * time.Millisecond)
+	if _, err := uc.ReserveRoomCode(""); err != nil {
+		t.Fatalf("expected the expired reservation to be released: %v", err)
+	}
+
+	host := dial(t, server, code)
+	host.WriteJSON(events.ClientEvent{EventType: events.CreateRoomEvent, ClientName: "host"})
+	if res, err := readEvent(host, events.CreateRoomEvent); err != nil || res["success"] != true {
+		t.Fatalf("expected the room to be created with the code: %v %v", res, err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	if code, err := uc.ReserveRoomCode(""); err == nil {
+		t.Errorf("expected the code of a live room not to be handed out, got %q", code)
+	}
+
+	// the rooms are only created under a reserved code
+	squatter := dial(t, server, "bb")
+	squatter.WriteJSON(events.ClientEvent{EventType: events.CreateRoomEvent, ClientName: "squatter"})
+	if res, err := readEvent(squatter, events.CreateRoomEvent); err != nil || res["success"] != false {
+		t.Errorf("expected a room without reservation to be refused, got %v %v", res, err)
+	}
+
+	configs.Constant.RoomCodeWords = true
+	uc = usecases.NewGameUsecase()
+	code, err = uc.ReserveRoomCode("")
+	if matched, _ := regexp.MatchString(`^[a-z]+-[a-z]+-[1-9][0-9]$`, code); err != nil || !matched {
+		t.Errorf("expected a word code, got %q %v", code, err)
+	}
+
+	// the reservations of an IP are limited
+	for i := 0; i < 20; i++ {
+		if _, err = uc.ReserveRoomCode("10.0.0.1"); err != nil {
+			break
+		}
+	}
+	if err != usecases.ErrTooManyRoomCodes {
+		t.Errorf("expected ErrTooManyRoomCodes, got %v", err)
+	}
+	if _, err := uc.ReserveRoomCode("10.0.0.2"); err != nil {
+		t.Errorf("expected another IP to reserve a code, got %v", err)
+	}
+}
+
+func TestStaleConnection(t *testing.T) {
+	defaults := *configs.Constant
+	t.Cleanup(func() { *configs.Constant = defaults })
+	configs.Constant.PingInterval = 50 * time.Millisecond
+	configs.Constant.PongWait = 150 * time.Millisecond
+	configs.Constant.MaxMessageSize = 1024
+	server := newTestServer(t, 100*time.Millisecond, 0)
+
+	roomID := newRoomID(t, server)
+	host := dial(t, server, roomID)
+	host.WriteJSON(events.ClientEvent{EventType: events.CreateRoomEvent, ClientName: "host"})
+	if _, err := readEvent(host, events.CreateRoomEvent); err != nil {
+		t.Fatalf("failed to create the room: %v", err)
+	}
+
+	// the guest keeps reading, so it answers the pings
+	guest := dial(t, server, roomID)
+	guest.WriteJSON(events.ClientEvent{EventType: events.JoinRoomEvent, ClientName: "guest"})
+	joined, err := readEvent(guest, events.JoinRoomEvent)
+	if err != nil {
+		t.Fatalf("failed to join the room: %v", err)
+	}
+
+	// the host stops reading, it is dropped once its grace period is over
+	if res, err := readEvent(guest, events.HostChangedEvent); err != nil || res["id_player"] != joined["id_player"] {
+		t.Errorf("expected the guest to become the host, got %v %v", res, err)
+	}
+
+	guest.WriteJSON(events.ClientEvent{EventType: events.ChatEvent, Message: strings.Repeat("a", 2048)})
+	if _, err := readEvent(guest, events.ChatEvent); err == nil {
+		t.Errorf("expected an oversized message to close the connection")
+	}
+
+	// a client that is in no room yet is pinged too
+	idleRoomID := newRoomID(t, server)
+	idle := dial(t, server, idleRoomID)
+	var created map[string]interface{}
+	done := make(chan error)
+	go func() {
+		var err error
+		created, err = readEvent(idle, events.CreateRoomEvent)
+		done <- err
+	}()
+	time.Sleep(3 * configs.Constant.PongWait)
+	idle.WriteJSON(events.ClientEvent{EventType: events.CreateRoomEvent, ClientName: "idle"})
+	if err := <-done; err != nil || created["success"] != true {
+		t.Errorf("expected the idle client to create a room, got %v %v", created, err)
+	}
+}
+
+func TestRepliesWhileBroadcasting(t *testing.T) {
+	server := newTestServer(t, time.Minute, 0)
+
+	roomID := newRoomID(t, server)
+	host := dial(t, server, roomID)
+	host.WriteJSON(events.ClientEvent{EventType: events.CreateRoomEvent, ClientName: "host"})
+	if _, err := readEvent(host, events.CreateRoomEvent); err != nil {
+		t.Fatalf("failed to create the room: %v", err)
+	}
+	guest := dial(t, server, roomID)
+	guest.WriteJSON(events.ClientEvent{EventType: events.JoinRoomEvent, ClientName: "guest"})
+	if _, err := readEvent(guest, events.JoinRoomEvent); err != nil {
+		t.Fatalf("failed to join the room: %v", err)
+	}
+
+	// the replies to the host are written while the chat is broadcast to it
+	const attempts = 50
+	go func() {
+		for i := 0; i < attempts; i++ {
+			guest.WriteJSON(events.ClientEvent{EventType: events.ChatEvent, Message: "hi"})
+		}
+	}()
+	for i := 0; i < attempts; i++ {
+		host.WriteJSON(events.ClientEvent{EventType: events.CreateRoomEvent, ClientName: "host"})
+	}
+	for i := 0; i < attempts; i++ {
+		if res, err := readEvent(host, events.CreateRoomEvent); err != nil || res["success"] != false {
+			t.Fatalf("expected the room to exist already, got %v %v", res, err)
+		}
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	defaults := *configs.Constant
+	t.Cleanup(func() { *configs.Constant = defaults })
+	configs.Constant.Capacity = 100
+	configs.Constant.ShutdownNotice = 500 * time.Millisecond
+	configs.Constant.SnapshotPath = filepath.Join(t.TempDir(), "rooms.json")
+	uc := usecases.NewGameUsecase()
+	server := serve(t, uc)
+
+	roomID := newRoomID(t, server)
+	host := dial(t, server, roomID)
+	host.WriteJSON(events.ClientEvent{EventType: events.CreateRoomEvent, ClientName: "host"})
+	if _, err := readEvent(host, events.CreateRoomEvent); err != nil {
+		t.Fatalf("failed to create the room: %v", err)
+	}
+	queued := dial(t, server, "matchmaking")
+	queued.WriteJSON(events.MatchRequest{EventType: events.QueueMatchEvent, Difficulty: "easy"})
+	if _, err := readEvent(queued, events.QueuePositionEvent); err != nil {
+		t.Fatalf("failed to queue: %v", err)
+	}
+	// a client that is in no room yet
+	idle := dial(t, server, roomID)
+
+	shutdownErr := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdownErr <- uc.Shutdown(ctx)
+	}()
+
+	if res, err := readEvent(host, events.ServerShutdownEvent); err != nil || res["shutdown_at"] == nil {
+		t.Fatalf("expected a shutdown notice, got %v %v", res, err)
+	}
+	late := dial(t, server, roomID)
+	late.WriteJSON(events.ClientEvent{EventType: events.JoinRoomEvent, ClientName: "late"})
+	if res, err := readEvent(late, events.JoinRoomEvent); err != nil || res["reason"] != string(events.ErrorCodeShuttingDown) {
+		t.Errorf("expected the joins to be refused, got %v %v", res, err)
+	}
+	if res, err := readEvent(queued, events.MatchFailedEvent); err != nil || res["reason"] != string(events.ErrorCodeShuttingDown) {
+		t.Errorf("expected the queue to be closed, got %v %v", res, err)
+	}
+
+	for _, conn := range []*websocket.Conn{host, queued, idle} {
+		if _, err := readEvent(conn, events.ServerShutdownEvent); !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+			t.Errorf("expected the connection to be closed as going away, got %v", err)
+		}
+	}
+	if err := <-shutdownErr; err != nil {
+		t.Fatalf("expected the shutdown to complete: %v", err)
+	}
+
+	payload, err := os.ReadFile(configs.Constant.SnapshotPath)
+	if err != nil {
+		t.Fatalf("expected the rooms to be saved: %v", err)
+	}
+	var snapshot struct {
+		Rooms []map[string]interface{} `json:"rooms"`
+	}
+	if err := json.Unmarshal(payload, &snapshot); err != nil || len(snapshot.Rooms) != 1 || snapshot.Rooms[0]["id_room"] != roomID {
+		t.Errorf("expected the room to be saved, got %s %v", payload, err)
+	}
+}
@@ -0,0 +1,90 @@
+package usecases
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// maxFailedJoins is the number of failed attempts to join a room a client
+	// IP gets per failedJoinWindow
+	maxFailedJoins   = 5
+	failedJoinWindow = time.Minute
+)
+
+// attemptLimiter counts the attempts of each client IP, usually the failed
+// ones, and refuses the IPs that made too many until their window is over.
+type attemptLimiter struct {
+	mu       sync.Mutex
+	max      int
+	window   time.Duration
+	attempts map[string]*attempts
+	// lastSweep is when the expired windows were last forgotten
+	lastSweep time.Time
+}
+
+type attempts struct {
+	count int
+	since time.Time
+}
+
+func newAttemptLimiter(max int, window time.Duration) *attemptLimiter {
+	return &attemptLimiter{
+		max:       max,
+		window:    window,
+		attempts:  make(map[string]*attempts),
+		lastSweep: time.Now(),
+	}
+}
+
+// allow tells whether the IP may attempt again.
+func (l *attemptLimiter) allow(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.allowed(ip)
+}
+
+// fail records a failed attempt of the IP.
+func (l *attemptLimiter) fail(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.record(ip)
+}
+
+// take records an attempt of the IP, unless it may not attempt again.
+func (l *attemptLimiter) take(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.allowed(ip) {
+		return false
+	}
+	l.record(ip)
+	return true
+}
+
+func (l *attemptLimiter) allowed(ip string) bool {
+	a, ok := l.attempts[ip]
+	return !ok || a.count < l.max || time.Since(a.since) >= l.window
+}
+
+func (l *attemptLimiter) record(ip string) {
+	now := time.Now()
+	if now.Sub(l.lastSweep) >= l.window {
+		for key, a := range l.attempts {
+			if now.Sub(a.since) >= l.window {
+				delete(l.attempts, key)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	a, ok := l.attempts[ip]
+	if !ok || now.Sub(a.since) >= l.window {
+		a = &attempts{since: now}
+		l.attempts[ip] = a
+	}
+	a.count++
+}
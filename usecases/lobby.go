@@ -0,0 +1,119 @@
+package usecases
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/aryuuu/mines-party-server/events"
+)
+
+// lobbySubscriberSize is the number of updates buffered for a lobby
+// subscriber, a subscriber falling further behind is dropped
+const lobbySubscriberSize = 64
+
+// lobby pushes the changes of the public rooms to its subscribers.
+type lobby struct {
+	mu          sync.Mutex
+	subscribers map[chan *events.LobbyUpdate]struct{}
+	// closed ends the subscriptions, the new ones end right away
+	closed bool
+}
+
+func newLobby() *lobby {
+	return &lobby{
+		subscribers: make(map[chan *events.LobbyUpdate]struct{}),
+	}
+}
+
+// subscribe returns the channel of the lobby updates and the function to stop
+// them. The channel is closed once unsubscribed or when the subscriber does
+// not keep up, it should then fetch the rooms again.
+func (l *lobby) subscribe() (<-chan *events.LobbyUpdate, func()) {
+	updates := make(chan *events.LobbyUpdate, lobbySubscriberSize)
+
+	l.mu.Lock()
+	if l.closed {
+		close(updates)
+	} else {
+		l.subscribers[updates] = struct{}{}
+	}
+	l.mu.Unlock()
+
+	return updates, func() {
+		l.mu.Lock()
+		l.drop(updates)
+		l.mu.Unlock()
+	}
+}
+
+func (l *lobby) publish(update *events.LobbyUpdate) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for updates := range l.subscribers {
+		select {
+		case updates <- update:
+		default:
+			l.drop(updates)
+		}
+	}
+}
+
+// close ends every subscription.
+func (l *lobby) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.closed = true
+	for updates := range l.subscribers {
+		l.drop(updates)
+	}
+}
+
+func (l *lobby) drop(updates chan *events.LobbyUpdate) {
+	if _, ok := l.subscribers[updates]; ok {
+		delete(l.subscribers, updates)
+		close(updates)
+	}
+}
+
+// ListRooms returns the public rooms ordered by ID.
+func (u *gameUsecase) ListRooms() []events.LobbyRoom {
+	rooms := []events.LobbyRoom{}
+	for _, r := range u.rooms.list() {
+		if r.GameRoom.IsPublic() {
+			rooms = append(rooms, events.NewLobbyRoom(r.GameRoom))
+		}
+	}
+	sort.Slice(rooms, func(i, j int) bool {
+		return rooms[i].RoomID < rooms[j].RoomID
+	})
+	return rooms
+}
+
+// SubscribeLobby returns the updates of the public rooms, see lobby.subscribe.
+func (u *gameUsecase) SubscribeLobby() (<-chan *events.LobbyUpdate, func()) {
+	return u.lobby.subscribe()
+}
+
+// refreshLobby publishes the room when its summary changed since the last
+// event, it runs on the goroutine of the room after each event.
+func (u *gameUsecase) refreshLobby(r *room) {
+	var listed *events.LobbyRoom
+	if !r.isClosed() && r.GameRoom.IsPublic() {
+		lobbyRoom := events.NewLobbyRoom(r.GameRoom)
+		listed = &lobbyRoom
+	}
+
+	switch {
+	case listed == nil && r.listed == nil:
+		return
+	case listed == nil:
+		u.lobby.publish(events.NewLobbyRoomRemoved(r.ID))
+	case r.listed != nil && *listed == *r.listed:
+		return
+	default:
+		u.lobby.publish(events.NewLobbyRoomUpdated(*listed))
+	}
+	r.listed = listed
+}
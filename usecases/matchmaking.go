@@ -0,0 +1,367 @@
+package usecases
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aryuuu/mines-party-server/events"
+	"github.com/aryuuu/mines-party-server/minesweeper"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// matchInterval is how often the queue is checked for the tickets that
+	// timed out or can be matched with a wider rating spread
+	matchInterval    = time.Second
+	defaultGroupSize = 2
+	maxGroupSize     = 8
+	// matchJoinTimeout is how long the matched clients have to join their
+	// room, an empty room is deleted afterwards
+	matchJoinTimeout = 30 * time.Second
+	// ratingSpread is the largest rating difference of a group, it grows by
+	// ratingSpreadGrowth for every second waited so that no one waits forever
+	ratingSpread       = 100
+	ratingSpreadGrowth = 10
+)
+
+// ticket is a client waiting in the matchmaking queue.
+type ticket struct {
+	c        *connection
+	request  events.MatchRequest
+	queuedAt time.Time
+	// position and queued were last sent to the client
+	position int
+	queued   int
+}
+
+// send queues the message unless the client does not keep up, the
+// matchmaker never waits for a client.
+func (t *ticket) send(message interface{}) {
+	t.c.trySend(message)
+}
+
+// reject closes the connection of a ticket turned away by the shutdown.
+func (t *ticket) reject() {
+	t.send(events.NewMatchFailedUnicast(events.ErrorCodeShuttingDown, "server is shutting down"))
+	t.c.close(goingAway)
+}
+
+// matchmaker groups the queued clients with the same preferences in the
+// order they queued. The tickets leaving the queue belong to whoever removed
+// them, which closes their queue.
+type matchmaker struct {
+	mu      sync.Mutex
+	tickets []*ticket
+	timeout time.Duration
+	// match opens a room for the group, it is called without the lock
+	match func(group []*ticket)
+	// closed turns away every ticket, stop ends run
+	closed bool
+	stop   chan struct{}
+}
+
+func newMatchmaker(timeout time.Duration, match func(group []*ticket)) *matchmaker {
+	return &matchmaker{
+		timeout: timeout,
+		match:   match,
+		stop:    make(chan struct{}),
+	}
+}
+
+// run matches the queue at every interval until the matchmaker is closed.
+func (m *matchmaker) run() {
+	ticker := time.NewTicker(matchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			m.update(now, nil)
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// close turns away the queued tickets and the ones to come.
+func (m *matchmaker) close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return
+	}
+	m.closed = true
+	close(m.stop)
+	for _, t := range m.tickets {
+		t.reject()
+	}
+	m.tickets = nil
+}
+
+func (m *matchmaker) enqueue(t *ticket) {
+	m.update(t.queuedAt, t)
+}
+
+// update adds the ticket to the queue if any, then drops the tickets that
+// timed out and matches the rest.
+func (m *matchmaker) update(now time.Time, t *ticket) {
+	m.mu.Lock()
+	if m.closed {
+		if t != nil {
+			t.reject()
+		}
+		m.mu.Unlock()
+		return
+	}
+	if t != nil {
+		m.tickets = append(m.tickets, t)
+	}
+	m.expire(now)
+	groups := m.collect(now)
+	m.notifyPositions()
+	m.mu.Unlock()
+
+	for _, group := range groups {
+		m.match(group)
+	}
+}
+
+// leave takes the ticket out of the queue, unless it already left.
+func (m *matchmaker) leave(t *ticket) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, queued := range m.tickets {
+		if queued == t {
+			m.tickets = append(m.tickets[:i], m.tickets[i+1:]...)
+			t.c.close(nil)
+			m.notifyPositions()
+			return
+		}
+	}
+}
+
+func (m *matchmaker) expire(now time.Time) {
+	remaining := m.tickets[:0]
+	for _, t := range m.tickets {
+		if now.Sub(t.queuedAt) < m.timeout {
+			remaining = append(remaining, t)
+			continue
+		}
+		t.send(events.NewMatchFailedUnicast(events.ErrorCodeMatchTimeout, "no match found in time"))
+		t.c.close(nil)
+	}
+	m.tickets = remaining
+}
+
+// collect removes the groups that can be matched from the queue, each one
+// formed around its oldest ticket.
+func (m *matchmaker) collect(now time.Time) [][]*ticket {
+	var groups [][]*ticket
+	taken := make(map[*ticket]bool)
+	for i, anchor := range m.tickets {
+		if taken[anchor] {
+			continue
+		}
+
+		group := []*ticket{anchor}
+		for _, candidate := range m.tickets[i+1:] {
+			if len(group) == anchor.request.GroupSize {
+				break
+			}
+			if !taken[candidate] && isCompatible(anchor, candidate, now) {
+				group = append(group, candidate)
+			}
+		}
+		if len(group) < anchor.request.GroupSize {
+			continue
+		}
+
+		for _, t := range group {
+			taken[t] = true
+		}
+		groups = append(groups, group)
+	}
+
+	remaining := m.tickets[:0]
+	for _, t := range m.tickets {
+		if !taken[t] {
+			remaining = append(remaining, t)
+		}
+	}
+	m.tickets = remaining
+	return groups
+}
+
+// notifyPositions tells the clients whose position changed.
+func (m *matchmaker) notifyPositions() {
+	queued := make(map[events.MatchRequest]int)
+	for _, t := range m.tickets {
+		queued[poolOf(t)]++
+	}
+
+	positions := make(map[events.MatchRequest]int)
+	for _, t := range m.tickets {
+		pool := poolOf(t)
+		positions[pool]++
+		if t.position == positions[pool] && t.queued == queued[pool] {
+			continue
+		}
+
+		t.position, t.queued = positions[pool], queued[pool]
+		t.send(events.NewQueuePositionUnicast(t.position, t.queued))
+	}
+}
+
+// poolOf returns the preferences a ticket must share to be grouped.
+func poolOf(t *ticket) events.MatchRequest {
+	return events.MatchRequest{
+		Difficulty: t.request.Difficulty,
+		Mode:       t.request.Mode,
+		GroupSize:  t.request.GroupSize,
+	}
+}
+
+// isCompatible tells whether the candidate can join the group of the anchor,
+// which waited the longest. The unrated clients match any rating.
+func isCompatible(anchor, candidate *ticket, now time.Time) bool {
+	if poolOf(anchor) != poolOf(candidate) {
+		return false
+	}
+	if anchor.request.Rating == 0 || candidate.request.Rating == 0 {
+		return true
+	}
+
+	spread := ratingSpread + ratingSpreadGrowth*int(now.Sub(anchor.queuedAt)/time.Second)
+	diff := anchor.request.Rating - candidate.request.Rating
+	return -spread <= diff && diff <= spread
+}
+
+// validateMatchRequest fills in the default preferences and checks the rest.
+func validateMatchRequest(request *events.MatchRequest) error {
+	if request.Mode == "" {
+		request.Mode = minesweeper.MODE_CLASSIC
+	}
+	if request.GroupSize == 0 {
+		request.GroupSize = defaultGroupSize
+	}
+
+	if !minesweeper.IsDifficulty(request.Difficulty) {
+		return fmt.Errorf("unknown difficulty %q", request.Difficulty)
+	}
+	if !minesweeper.IsMode(request.Mode) {
+		return fmt.Errorf("unknown mode %q", request.Mode)
+	}
+	if request.GroupSize < 2 || request.GroupSize > maxGroupSize {
+		return fmt.Errorf("group size must be between 2 and %d", maxGroupSize)
+	}
+	if request.Rating < 0 {
+		return fmt.Errorf("rating must not be negative")
+	}
+	return nil
+}
+
+// Matchmake queues the client until it is matched, it times out or it leaves
+// the queue.
+func (u *gameUsecase) Matchmake(conn *websocket.Conn) {
+	c, untrack, ok := u.trackClient(conn)
+	if !ok {
+		refuseClient(conn, u.writeWait)
+		return
+	}
+	defer untrack()
+	u.keepAlive(conn)
+
+	var request events.MatchRequest
+	if err := conn.ReadJSON(&request); err != nil {
+		log.Printf("failed to read the match request: %v", err)
+		return
+	}
+
+	if u.draining.Load() {
+		c.send(events.NewMatchFailedUnicast(events.ErrorCodeShuttingDown, "server is shutting down"))
+		return
+	}
+
+	err := validateMatchRequest(&request)
+	if err == nil && request.EventType != events.QueueMatchEvent {
+		err = fmt.Errorf("expected %s, got %s", events.QueueMatchEvent, request.EventType)
+	}
+	if err != nil {
+		c.send(events.NewMatchFailedUnicast(events.ErrorCodeInvalidPreferences, err.Error()))
+		return
+	}
+
+	t := &ticket{
+		c:        c,
+		request:  request,
+		queuedAt: time.Now(),
+	}
+	u.matchmaker.enqueue(t)
+
+	// the connection is closed once the ticket leaves the queue
+	for {
+		var clientEvent events.MatchRequest
+		if err := conn.ReadJSON(&clientEvent); err != nil || clientEvent.EventType == events.LeaveQueueEvent {
+			break
+		}
+	}
+	u.matchmaker.leave(t)
+}
+
+// startMatch opens a room for the group and sends each client an invite to it.
+func (u *gameUsecase) startMatch(group []*ticket) {
+	defer func() {
+		for _, t := range group {
+			t.c.close(nil)
+		}
+	}()
+
+	r, err := u.openMatchRoom(group[0].request)
+	if err != nil {
+		log.Printf("failed to open a room for a match: %v", err)
+		for _, t := range group {
+			t.send(events.NewMatchFailedUnicast(events.ErrorCodeServerFull, "server is full"))
+		}
+		return
+	}
+
+	expiresAt := time.Now().Add(matchJoinTimeout)
+	invite := signInvite(r, expiresAt)
+	for _, t := range group {
+		t.send(events.NewMatchFoundUnicast(r.ID, invite, expiresAt))
+	}
+	log.Printf("matched %d players in room %s", len(group), r.ID)
+
+	time.AfterFunc(matchJoinTimeout, func() {
+		r.post(roomEvent{task: func() {
+			if r.GameRoom.IsEmpty() {
+				u.deleteRoom(r)
+			}
+		}})
+	})
+}
+
+// openMatchRoom opens an invite only room with the preferences of the match,
+// its first player becomes the host.
+func (u *gameUsecase) openMatchRoom(request events.MatchRequest) (*room, error) {
+	code, err := u.codes.reserve()
+	if err != nil {
+		return nil, err
+	}
+
+	r := newRoom(code, "", request.GroupSize)
+	settings := r.GameRoom.GetSettings()
+	settings.Difficulty = request.Difficulty
+	settings.Mode = request.Mode
+	settings.InviteOnly = true
+	r.GameRoom.UpdateSettings(settings)
+
+	if err := u.openRoom(r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
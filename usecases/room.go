@@ -0,0 +1,309 @@
+package usecases
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aryuuu/mines-party-server/events"
+	"github.com/aryuuu/mines-party-server/minesweeper"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// roomMailboxSize is the number of events a room buffers before the
+	// senders block
+	roomMailboxSize = 256
+	// defaultRoomCapacity is the number of players of the rooms created by
+	// the clients
+	defaultRoomCapacity = 4
+)
+
+var (
+	errRoomExists  = errors.New("room already exists")
+	errServerFull  = errors.New("server is full")
+	errNotReserved = errors.New("room code is not reserved")
+)
+
+// roomEvent is an entry of the mailbox of a room, either an event sent by one
+// of its clients or a task to run on behalf of a timer or a new connection.
+type roomEvent struct {
+	conn  *websocket.Conn
+	event events.ClientEvent
+	task  func()
+}
+
+// room runs a game room as an actor: a single goroutine owns the connections
+// of the room and handles its mailbox one event at a time, so only that
+// goroutine touches conns, graceTimers and stopScoreCron.
+type room struct {
+	ID       string
+	GameRoom *minesweeper.GameRoom
+	// key tells apart the rooms that had the same ID over time
+	key string
+
+	conns map[*websocket.Conn]*connection
+	// graceTimers holds the players that lost their connection, they leave
+	// the room when their timer fires
+	graceTimers   map[string]*time.Timer
+	stopScoreCron chan struct{}
+	// listed is the summary last published to the lobby, nil when the room
+	// is not listed
+	listed *events.LobbyRoom
+
+	mailbox chan roomEvent
+	// done is closed once the room is deleted, the events still in the
+	// mailbox are dropped
+	done chan struct{}
+}
+
+func newRoom(roomID string, hostID string, capacity int) *room {
+	return &room{
+		ID:          roomID,
+		GameRoom:    minesweeper.NewGameRoom(roomID, hostID, capacity),
+		key:         uuid.NewString(),
+		conns:       make(map[*websocket.Conn]*connection),
+		graceTimers: make(map[string]*time.Timer),
+		mailbox:     make(chan roomEvent, roomMailboxSize),
+		done:        make(chan struct{}),
+	}
+}
+
+// post puts the event in the mailbox of the room and returns false when the
+// room is already deleted.
+func (r *room) post(event roomEvent) bool {
+	select {
+	case r.mailbox <- event:
+		return true
+	case <-r.done:
+		return false
+	}
+}
+
+// run handles the mailbox of the room until it is closed.
+func (r *room) run(handle func(r *room, event roomEvent)) {
+	for {
+		select {
+		case event := <-r.mailbox:
+			handle(r, event)
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// close deletes the room, it must be called from the room goroutine.
+func (r *room) close() {
+	r.stopCron()
+	// stops the timed events of a running game
+	r.GameRoom.End()
+	for conn := range r.conns {
+		r.removeConn(conn)
+	}
+	for playerID := range r.graceTimers {
+		r.stopGrace(playerID)
+	}
+	close(r.done)
+}
+
+func (r *room) isClosed() bool {
+	select {
+	case <-r.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// stopCron stops the score cron of the room, if any.
+func (r *room) stopCron() {
+	if r.stopScoreCron != nil {
+		close(r.stopScoreCron)
+		r.stopScoreCron = nil
+	}
+}
+
+func (r *room) addConn(conn *websocket.Conn, c *connection) {
+	r.conns[conn] = c
+}
+
+// removeConn forgets the connection, its write pump sends what is left in
+// the queue and closes it.
+func (r *room) removeConn(conn *websocket.Conn) {
+	c, ok := r.conns[conn]
+	if !ok {
+		return
+	}
+	delete(r.conns, conn)
+	c.close(nil)
+}
+
+// stopGrace keeps the player in the room, it returns whether the player was
+// disconnected.
+func (r *room) stopGrace(playerID string) bool {
+	timer, ok := r.graceTimers[playerID]
+	if !ok {
+		return false
+	}
+	timer.Stop()
+	delete(r.graceTimers, playerID)
+	return true
+}
+
+func (r *room) getPlayerID(conn *websocket.Conn) (string, bool) {
+	c, ok := r.conns[conn]
+	if !ok {
+		return "", false
+	}
+	return c.ID, true
+}
+
+func (r *room) findConn(playerID string) (*websocket.Conn, bool) {
+	for conn, c := range r.conns {
+		if c.ID == playerID {
+			return conn, true
+		}
+	}
+	return nil, false
+}
+
+// pushUnicastMessage sends the message to a single client of the room. The
+// clients that are not part of the room yet are answered through their own
+// connection by the caller.
+func (r *room) pushUnicastMessage(conn *websocket.Conn, message interface{}) {
+	c, ok := r.conns[conn]
+	if !ok {
+		log.Printf("dropped a message to a client out of room %s", r.ID)
+		return
+	}
+	c.send(message)
+}
+
+func (r *room) pushBroadcastMessage(message interface{}) {
+	for _, c := range r.conns {
+		c.send(message)
+	}
+}
+
+// roomRegistry keeps track of the running rooms, it is shared by every
+// connection.
+type roomRegistry struct {
+	mu    sync.RWMutex
+	rooms map[string]*room
+	// members is the number of players and spectators over all the rooms
+	members int
+	// reserved holds the codes handed out for rooms yet to be created, until
+	// their deadline
+	reserved map[string]time.Time
+	// lastSweep is when the expired reservations were last forgotten
+	lastSweep time.Time
+}
+
+func newRoomRegistry() *roomRegistry {
+	return &roomRegistry{
+		rooms:     make(map[string]*room),
+		reserved:  make(map[string]time.Time),
+		lastSweep: time.Now(),
+	}
+}
+
+func (rr *roomRegistry) get(roomID string) (*room, bool) {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+
+	r, ok := rr.rooms[roomID]
+	return r, ok
+}
+
+// list returns the running rooms.
+func (rr *roomRegistry) list() []*room {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+
+	rooms := make([]*room, 0, len(rr.rooms))
+	for _, r := range rr.rooms {
+		rooms = append(rooms, r)
+	}
+	return rooms
+}
+
+// add registers a new room under a reserved code, unless the code is taken,
+// its reservation expired or there are already capacity rooms.
+func (rr *roomRegistry) add(r *room, capacity int) error {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	if len(rr.rooms) >= capacity {
+		return errServerFull
+	}
+	if _, ok := rr.rooms[r.ID]; ok {
+		return errRoomExists
+	}
+	if until, ok := rr.reserved[r.ID]; !ok || !time.Now().Before(until) {
+		return errNotReserved
+	}
+	rr.rooms[r.ID] = r
+	delete(rr.reserved, r.ID)
+	return nil
+}
+
+// reserve holds the code for a room created before the deadline, unless the
+// code is taken by a room or another reservation.
+func (rr *roomRegistry) reserve(code string, deadline time.Time, capacity int) error {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(rr.lastSweep) >= time.Minute {
+		for reserved, until := range rr.reserved {
+			if !now.Before(until) {
+				delete(rr.reserved, reserved)
+			}
+		}
+		rr.lastSweep = now
+	}
+
+	if len(rr.rooms) >= capacity {
+		return errServerFull
+	}
+	if _, ok := rr.rooms[code]; ok {
+		return errRoomExists
+	}
+	if until, ok := rr.reserved[code]; ok && now.Before(until) {
+		return errRoomExists
+	}
+	rr.reserved[code] = deadline
+	return nil
+}
+
+// reserveMember makes room for a new player or spectator, unless there are
+// already limit of them. A limit of 0 means no limit.
+func (rr *roomRegistry) reserveMember(limit int) bool {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	if limit > 0 && rr.members >= limit {
+		return false
+	}
+	rr.members++
+	return true
+}
+
+func (rr *roomRegistry) releaseMember() {
+	rr.mu.Lock()
+	rr.members--
+	rr.mu.Unlock()
+}
+
+// remove unregisters the room, unless its ID was already taken by a newer
+// room.
+func (rr *roomRegistry) remove(r *room) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	if rr.rooms[r.ID] == r {
+		delete(rr.rooms, r.ID)
+	}
+}
@@ -0,0 +1,87 @@
+package usecases
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aryuuu/mines-party-server/configs"
+)
+
+const (
+	sessionTokenKind = "session"
+	inviteTokenKind  = "invite"
+)
+
+// signToken returns a token carrying the given fields, it reads
+// base64(kind:field:...).base64(signature). The fields must not contain ':'.
+func signToken(kind string, fields ...string) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(strings.Join(append([]string{kind}, fields...), ":")))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(tokenSignature(payload))
+}
+
+// verifyToken checks the signature and the kind of the token and returns its
+// fields.
+func verifyToken(kind string, token string) ([]string, bool) {
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, false
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(sig, tokenSignature(payload)) {
+		return nil, false
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, false
+	}
+	fields := strings.Split(string(decoded), ":")
+	if fields[0] != kind {
+		return nil, false
+	}
+	return fields[1:], true
+}
+
+func tokenSignature(payload string) []byte {
+	mac := hmac.New(sha256.New, configs.Constant.SessionSecret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// signSession returns the token a player presents to resume its session from
+// another connection.
+func signSession(roomID, playerID string) string {
+	return signToken(sessionTokenKind, roomID, playerID)
+}
+
+// verifySession returns the room and the player the token was issued for.
+func verifySession(token string) (string, string, bool) {
+	fields, ok := verifyToken(sessionTokenKind, token)
+	if !ok || len(fields) != 2 {
+		return "", "", false
+	}
+	return fields[0], fields[1], true
+}
+
+// signInvite returns a token letting its holder into the room until it
+// expires. It is bound to the room itself rather than its ID, which may be
+// taken again once the room is deleted.
+func signInvite(r *room, expiresAt time.Time) string {
+	return signToken(inviteTokenKind, r.ID, r.key, strconv.FormatInt(expiresAt.Unix(), 10))
+}
+
+// verifyInvite tells whether the token is a valid invite to the room.
+func verifyInvite(r *room, token string) bool {
+	fields, ok := verifyToken(inviteTokenKind, token)
+	if !ok || len(fields) != 3 || fields[0] != r.ID || fields[1] != r.key {
+		return false
+	}
+
+	expiresAt, err := strconv.ParseInt(fields[2], 10, 64)
+	return err == nil && time.Now().Unix() < expiresAt
+}
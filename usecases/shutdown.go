@@ -0,0 +1,167 @@
+package usecases
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aryuuu/mines-party-server/events"
+	"github.com/gorilla/websocket"
+)
+
+var errShuttingDown = errors.New("server is shutting down")
+
+// goingAway is the close message of the connections cut by the shutdown
+var goingAway = websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+
+// roomsSnapshot is what is left of the rooms at shutdown
+type roomsSnapshot struct {
+	SavedAt int64             `json:"saved_at"`
+	Rooms   []json.RawMessage `json:"rooms"`
+}
+
+// Shutdown refuses the new rooms, players and games, warns the clients and
+// closes their rooms once the notice is over, or as soon as ctx is done. It
+// returns once every connection is closed, or with the error of ctx.
+func (u *gameUsecase) Shutdown(ctx context.Context) error {
+	if u.draining.Swap(true) {
+		return errShuttingDown
+	}
+	u.matchmaker.close()
+	u.lobby.close()
+
+	rooms := u.rooms.list()
+	shutdownAt := time.Now().Add(u.shutdownNotice)
+	log.Printf("shutting down %d rooms at %s", len(rooms), shutdownAt.Format(time.RFC3339))
+	for _, r := range rooms {
+		r := r
+		r.post(roomEvent{task: func() {
+			r.pushBroadcastMessage(events.NewServerShutdownBroadcast(shutdownAt))
+		}})
+	}
+
+	select {
+	case <-time.After(u.shutdownNotice):
+	case <-ctx.Done():
+	}
+
+	snapshot := roomsSnapshot{SavedAt: time.Now().UnixMilli()}
+	if err := u.closeRooms(ctx, &snapshot); err != nil {
+		return err
+	}
+	if u.snapshotPath != "" {
+		u.saveSnapshot(snapshot)
+	}
+
+	// no pump starts once the clients are closed
+	u.closeClients()
+	pumpsDone := make(chan struct{})
+	go func() {
+		u.pumps.Wait()
+		close(pumpsDone)
+	}()
+	select {
+	case <-pumpsDone:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+// closeRooms closes every room, including the ones created while shutting
+// down, and saves them in the snapshot.
+func (u *gameUsecase) closeRooms(ctx context.Context, snapshot *roomsSnapshot) error {
+	var mu sync.Mutex
+	for rooms := u.rooms.list(); len(rooms) > 0; rooms = u.rooms.list() {
+		for _, r := range rooms {
+			r := r
+			r.post(roomEvent{task: func() {
+				if u.snapshotPath != "" {
+					u.snapshotRoom(r, snapshot, &mu)
+				}
+				for _, c := range r.conns {
+					c.close(goingAway)
+				}
+				u.deleteRoom(r)
+			}})
+		}
+
+		for _, r := range rooms {
+			select {
+			case <-r.done:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	return nil
+}
+
+func (u *gameUsecase) snapshotRoom(r *room, snapshot *roomsSnapshot, mu *sync.Mutex) {
+	room, err := json.Marshal(r.GameRoom)
+	if err != nil {
+		log.Printf("failed to save room %v: %v", r.ID, err)
+		return
+	}
+
+	mu.Lock()
+	snapshot.Rooms = append(snapshot.Rooms, room)
+	mu.Unlock()
+}
+
+// closeClients closes the connections left and refuses the new ones.
+func (u *gameUsecase) closeClients() {
+	u.clientsMu.Lock()
+	defer u.clientsMu.Unlock()
+
+	for _, c := range u.clients {
+		c.close(goingAway)
+	}
+	u.clients = nil
+}
+
+// trackClient starts the write pump of a new connection and keeps it until
+// the returned function closes it, so that the shutdown can close it too. It
+// returns false once the shutdown closed the clients.
+func (u *gameUsecase) trackClient(conn *websocket.Conn) (*connection, func(), bool) {
+	u.clientsMu.Lock()
+	defer u.clientsMu.Unlock()
+
+	if u.clients == nil {
+		return nil, nil, false
+	}
+	c := NewConnection("", events.JSONBoardEncoding)
+	u.clients[conn] = c
+	u.pumps.Add(1)
+	go u.writePump(conn, c)
+
+	return c, func() {
+		c.close(nil)
+		u.clientsMu.Lock()
+		delete(u.clients, conn)
+		u.clientsMu.Unlock()
+	}, true
+}
+
+// refuseClient closes a connection that came in after the shutdown closed
+// the clients.
+func refuseClient(conn *websocket.Conn, wait time.Duration) {
+	conn.WriteControl(websocket.CloseMessage, goingAway, time.Now().Add(wait))
+	conn.Close()
+}
+
+func (u *gameUsecase) saveSnapshot(snapshot roomsSnapshot) {
+	payload, err := json.Marshal(snapshot)
+	if err == nil {
+		err = os.WriteFile(u.snapshotPath, payload, 0o600)
+	}
+	if err != nil {
+		log.Printf("failed to save the rooms: %v", err)
+		return
+	}
+	log.Printf("saved %d rooms to %s", len(snapshot.Rooms), u.snapshotPath)
+}
@@ -10,3 +10,11 @@ func GenerateRandomInt(min, max int) int {
 	rand.Seed(time.Now().UnixNano())
 	return min + rand.Intn(max-min)
 }
+
+// Abs returns the absolute value of x
+func Abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}